@@ -0,0 +1,426 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"strconv"
+	"time"
+)
+
+type userInfo struct {
+	ID     int
+	Name   string
+	Team   string
+	Admin  bool
+	Hidden bool
+}
+
+type submission struct {
+	User      string
+	Challenge string
+	Flag      string
+	Correct   bool
+	IP        string
+	Time      time.Time
+}
+
+// sharing is a wrong submission that matched the dynamic flag of Owner.
+type sharing struct {
+	User      string
+	Challenge string
+	Owner     string
+	Flag      string
+	Time      time.Time
+}
+
+func (u *user) isAdmin() (bool, error) {
+	return dbUserIsAdmin(u.db, u.id)
+}
+
+// isAdmin reports whether u is an admin by the flag in the database.
+func (ctf *ctf) isAdmin(u user) bool {
+	admin, err := u.isAdmin()
+	return err == nil && admin
+}
+
+// promoteAdmins grants admin rights to the existing users listed in the admins
+// of ctf.yml. It only runs when ctfEngine starts, listed names cannot be
+// registered on the web, so the accounts have to be created with the CLI.
+func (ctf *ctf) promoteAdmins() {
+	for _, name := range ctf.Configuration.Admins {
+		id, err := dbUserGetID(ctf.Storage, name)
+		if err != nil {
+			fmt.Printf("[ERROR] admin \"%s\" does not exist, create it with \"user create -admin\"!\n", name)
+			continue
+		}
+		if admin, err := dbUserIsAdmin(ctf.Storage, id); err == nil && admin {
+			continue
+		}
+		if err := dbUserSetAdmin(ctf.Storage, id, true); err != nil {
+			fmt.Printf("[ERROR] could not grant admin rights to \"%s\": %s!\n", name, err)
+			continue
+		}
+		fmt.Printf("[INFO] granted admin rights to \"%s\"\n", name)
+	}
+}
+
+func (ctf *ctf) ensureAdmin(c *fiber.Ctx) (user, error) {
+	u, err := ctf.ensureLoggedIn(c)
+	if err != nil {
+		return user{}, err
+	}
+	if !ctf.isAdmin(u) {
+		return user{}, fmt.Errorf("user is not an admin")
+	}
+	return u, nil
+}
+
+func (ctf *ctf) sessionIsAdmin(c *fiber.Ctx) bool {
+	_, err := ctf.ensureAdmin(c)
+	return err == nil
+}
+
+// setAdmin grants or revokes admin rights of the user with the given name.
+func (ctf *ctf) setAdmin(username string, admin bool) error {
+	id, err := dbUserGetID(ctf.Storage, username)
+	if err != nil {
+		return fmt.Errorf("user \"%s\" does not exist", username)
+	}
+	return dbUserSetAdmin(ctf.Storage, id, admin)
+}
+
+// deleteUser removes the user with the given name and all of its solves.
+// Teams left without members are removed as well.
+func (ctf *ctf) deleteUser(username string) error {
+	id, err := dbUserGetID(ctf.Storage, username)
+	if err != nil {
+		return fmt.Errorf("user \"%s\" does not exist", username)
+	}
+	teamID, _ := dbUserGetTeam(ctf.Storage, id)
+
+	if err := dbUserDelete(ctf.Storage, id); err != nil {
+		return err
+	}
+
+	if teamID != 0 {
+		members, _, err := dbTeamGetMembers(ctf.Storage, teamID)
+		if err != nil {
+			return err
+		}
+		if len(members) == 0 {
+			return dbTeamDelete(ctf.Storage, teamID)
+		}
+	}
+	return nil
+}
+
+func (ctf *ctf) users() ([]userInfo, error) {
+	var users []userInfo
+
+	rows, err := dbGetUsers(ctf.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		users = append(users, userInfo{
+			ID:     row[0].(int),
+			Name:   row[1].(string),
+			Team:   row[2].(string),
+			Admin:  row[3].(bool),
+			Hidden: row[4].(bool),
+		})
+	}
+
+	return users, nil
+}
+
+func (ctf *ctf) submissions() ([]submission, error) {
+	var submissions []submission
+
+	rows, err := dbGetSubmissions(ctf.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		submissions = append(submissions, submission{
+			User:      row[0].(string),
+			Challenge: row[1].(string),
+			Flag:      row[2].(string),
+			Correct:   row[3].(bool),
+			IP:        row[4].(string),
+			Time:      row[5].(time.Time),
+		})
+	}
+
+	return submissions, nil
+}
+
+func (ctf *ctf) sharing() ([]sharing, error) {
+	var reports []sharing
+
+	rows, err := dbGetSharing(ctf.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		reports = append(reports, sharing{
+			User:      row[0].(string),
+			Challenge: row[1].(string),
+			Owner:     row[2].(string),
+			Flag:      row[3].(string),
+			Time:      row[4].(time.Time),
+		})
+	}
+
+	return reports, nil
+}
+
+func (ctf *ctf) resetPassword(userID int, password string) error {
+	if password == "" {
+		return fmt.Errorf("empty password cannot be used")
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	return dbUserSetPassword(ctf.Storage, userID, hash)
+}
+
+func rGetAdmin(c *fiber.Ctx, ctf *ctf) error {
+	users, err := ctf.users()
+	if err != nil {
+		return handleError(c, err)
+	}
+	return renderWithSession(c, *ctf, "admin/users", fiber.Map{
+		"Users": users,
+	})
+}
+
+func rGetAdminSubmissions(c *fiber.Ctx, ctf *ctf) error {
+	submissions, err := ctf.submissions()
+	if err != nil {
+		return handleError(c, err)
+	}
+	return renderWithSession(c, *ctf, "admin/submissions", fiber.Map{
+		"Submissions": submissions,
+	})
+}
+
+func rGetAdminSubmissionsCSV(c *fiber.Ctx, ctf *ctf) error {
+	submissions, err := ctf.submissions()
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	c.Set(fiber.HeaderContentType, "text/csv")
+	c.Attachment("submissions.csv")
+
+	w := csv.NewWriter(c)
+	_ = w.Write([]string{"time", "user", "challenge", "flag", "correct", "ip"})
+	for _, s := range submissions {
+		_ = w.Write([]string{
+			s.Time.Format(time.RFC3339), s.User, s.Challenge, s.Flag, strconv.FormatBool(s.Correct), s.IP,
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func rGetAdminSharing(c *fiber.Ctx, ctf *ctf) error {
+	reports, err := ctf.sharing()
+	if err != nil {
+		return handleError(c, err)
+	}
+	return renderWithSession(c, *ctf, "admin/sharing", fiber.Map{
+		"Reports": reports,
+	})
+}
+
+func rPostAdminReload(c *fiber.Ctx, ctf *ctf) error {
+	ctf.lock.RLock()
+	admin := ctf.sessionIsAdmin(c)
+	ctf.lock.RUnlock()
+	if !admin {
+		return c.Redirect("/")
+	}
+
+	err := ctf.reload()
+
+	ctf.lock.RLock()
+	defer ctf.lock.RUnlock()
+	switch err {
+	case nil:
+		ctf.addToast(c, "CTF reloaded",
+			fmt.Sprintf("The CTF has been reloaded with %d challenges.", len(ctf.Challenges)))
+	default:
+		ctf.addToast(c, "CTF reloaded with errors",
+			fmt.Sprintf("The CTF has been reloaded, but %s. See the log for details.", err))
+	}
+	return c.Redirect("/admin")
+}
+
+func rGetAdminTokens(c *fiber.Ctx, ctf *ctf) error {
+	tokens, err := dbGetSignupTokens(ctf.Storage)
+	if err != nil {
+		return handleError(c, err)
+	}
+	return renderWithSession(c, *ctf, "admin/tokens", fiber.Map{
+		"Tokens": tokens,
+	})
+}
+
+func rPostAdminTokens(c *fiber.Ctx, ctf *ctf) error {
+	payload := struct {
+		Count int `form:"count"`
+	}{}
+
+	if err := c.BodyParser(&payload); err != nil {
+		return err
+	}
+
+	for i := 0; i < max(payload.Count, 1); i++ {
+		if err := ctf.addSignupToken(uuid.New().String()); err != nil {
+			return handleError(c, err)
+		}
+	}
+	return c.Redirect("/admin/tokens")
+}
+
+func rPostAdminTokenRevoke(c *fiber.Ctx, ctf *ctf) error {
+	if err := dbDeleteSignupToken(ctf.Storage, c.Params("token")); err != nil {
+		return handleError(c, err)
+	}
+	return c.Redirect("/admin/tokens")
+}
+
+func rPostAdminUserPassword(c *fiber.Ctx, ctf *ctf) error {
+	payload := struct {
+		Password string `form:"password"`
+	}{}
+
+	if err := c.BodyParser(&payload); err != nil {
+		return err
+	}
+
+	userID, err := strconv.Atoi(c.Params("userID"))
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	err = ctf.resetPassword(userID, payload.Password)
+	switch err {
+	case nil:
+		ctf.addToast(c, "Password reset",
+			"The password has been reset.")
+	default:
+		ctf.addToast(c, "Password reset failed",
+			fmt.Sprintf("The password could not be reset: %s.", err))
+	}
+	return c.Redirect("/admin")
+}
+
+func rPostAdminUserHidden(c *fiber.Ctx, ctf *ctf) error {
+	payload := struct {
+		Hidden bool `form:"hidden"`
+	}{}
+
+	if err := c.BodyParser(&payload); err != nil {
+		return err
+	}
+
+	userID, err := strconv.Atoi(c.Params("userID"))
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	if err = dbUserSetHidden(ctf.Storage, userID, payload.Hidden); err != nil {
+		return handleError(c, err)
+	}
+	return c.Redirect("/admin")
+}
+
+func addAdminRoutes(app *fiber.App, ctf *ctf) {
+	admin := app.Group("/admin", func(c *fiber.Ctx) error {
+		if !ctf.sessionIsAdmin(c) {
+			ctf.addToast(c, "Admin needed",
+				"You need to be an admin to view this page.")
+			return c.Redirect("/")
+		}
+		return c.Next()
+	})
+
+	// list users
+	admin.Get("/", func(c *fiber.Ctx) error {
+		return rGetAdmin(c, ctf)
+	})
+
+	// reset the password of a user
+	admin.Post("/users/:userID/password", func(c *fiber.Ctx) error {
+		return rPostAdminUserPassword(c, ctf)
+	})
+
+	// hide or show a user on the scoreboard
+	admin.Post("/users/:userID/hidden", func(c *fiber.Ctx) error {
+		return rPostAdminUserHidden(c, ctf)
+	})
+
+	// list all submissions
+	admin.Get("/submissions", func(c *fiber.Ctx) error {
+		return rGetAdminSubmissions(c, ctf)
+	})
+
+	// export all submissions
+	admin.Get("/submissions.csv", func(c *fiber.Ctx) error {
+		return rGetAdminSubmissionsCSV(c, ctf)
+	})
+
+	// list suspected flag sharing
+	admin.Get("/sharing", func(c *fiber.Ctx) error {
+		return rGetAdminSharing(c, ctf)
+	})
+
+	// list, publish and delete announcements
+	admin.Get("/announcements", func(c *fiber.Ctx) error {
+		return rGetAdminAnnouncements(c, ctf)
+	})
+
+	admin.Post("/announcements", func(c *fiber.Ctx) error {
+		return rPostAdminAnnouncement(c, ctf)
+	})
+
+	admin.Post("/announcements/:announcementID/delete", func(c *fiber.Ctx) error {
+		return rPostAdminAnnouncementDelete(c, ctf)
+	})
+
+	// show and restart challenge services
+	admin.Get("/services", func(c *fiber.Ctx) error {
+		return rGetAdminServices(c, ctf)
+	})
+
+	admin.Post("/services/:challengeID/restart", func(c *fiber.Ctx) error {
+		return rPostAdminServiceRestart(c, ctf)
+	})
+
+	// show the health checks of challenge services
+	admin.Get("/health", func(c *fiber.Ctx) error {
+		return rGetAdminHealth(c, ctf)
+	})
+
+	// list, generate and revoke signup tokens
+	admin.Get("/tokens", func(c *fiber.Ctx) error {
+		return rGetAdminTokens(c, ctf)
+	})
+
+	admin.Post("/tokens", func(c *fiber.Ctx) error {
+		return rPostAdminTokens(c, ctf)
+	})
+
+	admin.Post("/tokens/:token/revoke", func(c *fiber.Ctx) error {
+		return rPostAdminTokenRevoke(c, ctf)
+	})
+}
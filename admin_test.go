@@ -0,0 +1,80 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// registerApp returns an app that registers name on POST /signup.
+func registerApp(ctf *ctf, name string) *fiber.App {
+	app := fiber.New()
+	app.Post("/signup", func(c *fiber.Ctx) error {
+		_, err := ctf.register(c, name, "password", "password", "")
+		return err
+	})
+	return app
+}
+
+func TestRegisterAdminName(t *testing.T) {
+	ctf := newTestCTF(t, "title: test\nsessionTimeout: 600\nadmins:\n  - alice\n", nil)
+
+	resp, err := registerApp(ctf, "alice").Test(httptest.NewRequest("POST", "/signup", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode == fiber.StatusOK {
+		t.Fatal("a name listed in admins could be registered")
+	}
+	if _, err := dbUserGetID(ctf.Storage, "alice"); err == nil {
+		t.Fatal("registering a name listed in admins created the user")
+	}
+
+	// accounts created by the operator are promoted on startup
+	id, err := ctf.createUser("alice", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctf.promoteAdmins()
+	if !ctf.isAdmin(user{id: id, db: ctf.Storage}) {
+		t.Fatal("existing user listed in admins was not promoted on startup")
+	}
+}
+
+func TestReloadDoesNotPromote(t *testing.T) {
+	ctf := newTestCTF(t, "title: test\nsessionTimeout: 600\n", nil)
+
+	resp, err := registerApp(ctf, "alice").Test(httptest.NewRequest("POST", "/signup", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("registration failed with status %d", resp.StatusCode)
+	}
+
+	// the account was self-registered before the name was listed
+	conf := "title: test\nsessionTimeout: 600\nadmins:\n  - alice\n"
+	if err := os.WriteFile(filepath.Join(ctf.Location, "ctf.yml"), []byte(conf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctf.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := dbUserGetID(ctf.Storage, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctf.isAdmin(user{id: id, db: ctf.Storage}) {
+		t.Fatal("reload promoted a self-registered user listed in admins")
+	}
+	users, err := ctf.users()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Admin {
+		t.Fatalf("users() lists alice as admin: %+v", users)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	announcementPriorityNormal = 0
+	announcementPriorityHigh   = 1
+)
+
+type announcement struct {
+	ID        int
+	Title     string
+	Text      string
+	Challenge string
+	Priority  int
+	Time      time.Time
+}
+
+func (a announcement) High() bool {
+	return a.Priority >= announcementPriorityHigh
+}
+
+// announcements returns all announcements after the one with the given ID,
+// the newest first.
+func (ctf *ctf) announcements(after int) ([]announcement, error) {
+	var announcements []announcement
+
+	rows, err := dbGetAnnouncements(ctf.Storage, after)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		announcements = append(announcements, announcement{
+			ID:        row[0].(int),
+			Title:     row[1].(string),
+			Text:      row[2].(string),
+			Challenge: row[3].(string),
+			Priority:  row[4].(int),
+			Time:      row[5].(time.Time),
+		})
+	}
+	return announcements, nil
+}
+
+// announce stores an announcement and pushes it to all connected browsers.
+// Logged-in users also get it as toast on their next request, see
+// announcementMiddleware.
+func (ctf *ctf) announce(title, text, challengeID string, priority int) error {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return fmt.Errorf("empty title cannot be used")
+	}
+	if _, ok := ctf.Challenges[challengeID]; challengeID != "" && !ok {
+		return fmt.Errorf("challenge \"%s\" does not exist", challengeID)
+	}
+	if priority != announcementPriorityHigh {
+		priority = announcementPriorityNormal
+	}
+
+	if _, err := dbInsertAnnouncement(ctf.Storage, title, text, challengeID, priority); err != nil {
+		return err
+	}
+
+	ctf.Events.publish(event{Type: eventAnnouncement, Title: "Announcement: " + title, Text: text})
+	return nil
+}
+
+// announcementMiddleware adds the announcements a logged-in user has not seen
+// yet as toasts to the session.
+func (ctf *ctf) announcementMiddleware(c *fiber.Ctx) error {
+	sess, err := ctf.Sessions.Get(c)
+	if err != nil {
+		return c.Next()
+	}
+	userID, ok := sess.Get("user").(int)
+	if !ok {
+		return c.Next()
+	}
+
+	seen, err := dbUserGetAnnouncement(ctf.Storage, userID)
+	if err != nil {
+		return c.Next()
+	}
+	announcements, err := ctf.announcements(seen)
+	if err != nil || len(announcements) == 0 {
+		return c.Next()
+	}
+
+	// oldest first, like they were published
+	for i := len(announcements) - 1; i >= 0; i-- {
+		ctf.addToast(c, "Announcement: "+announcements[i].Title, announcements[i].Text)
+	}
+	_ = dbUserSetAnnouncement(ctf.Storage, userID, announcements[0].ID)
+	return c.Next()
+}
+
+func rGetAnnouncements(c *fiber.Ctx, ctf *ctf) error {
+	announcements, err := ctf.announcements(0)
+	if err != nil {
+		return handleError(c, err)
+	}
+	return renderWithSession(c, *ctf, "announcements", fiber.Map{
+		"Announcements": announcements,
+	})
+}
+
+func rGetAdminAnnouncements(c *fiber.Ctx, ctf *ctf) error {
+	announcements, err := ctf.announcements(0)
+	if err != nil {
+		return handleError(c, err)
+	}
+	return renderWithSession(c, *ctf, "admin/announcements", fiber.Map{
+		"Announcements": announcements,
+	})
+}
+
+func rPostAdminAnnouncement(c *fiber.Ctx, ctf *ctf) error {
+	payload := struct {
+		Title     string `form:"title"`
+		Text      string `form:"text"`
+		Challenge string `form:"challenge"`
+		Priority  int    `form:"priority"`
+	}{}
+
+	if err := c.BodyParser(&payload); err != nil {
+		return err
+	}
+
+	err := ctf.announce(payload.Title, payload.Text, payload.Challenge, payload.Priority)
+	if err != nil {
+		ctf.addToast(c, "Announcement failed",
+			fmt.Sprintf("The announcement could not be published: %s.", err))
+	}
+	return c.Redirect("/admin/announcements")
+}
+
+func rPostAdminAnnouncementDelete(c *fiber.Ctx, ctf *ctf) error {
+	id, err := strconv.Atoi(c.Params("announcementID"))
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	if err = dbDeleteAnnouncement(ctf.Storage, id); err != nil {
+		return handleError(c, err)
+	}
+	return c.Redirect("/admin/announcements")
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"slices"
+	"sort"
+	"time"
+)
+
+type apiChallenge struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Category    string    `json:"category"`
+	Value       int       `json:"value"`
+	Solves      int       `json:"solves"`
+	Solved      bool      `json:"solved"`
+	Locked      bool      `json:"locked"`
+	Description string    `json:"description,omitempty"`
+	Files       []apiFile `json:"files,omitempty"`
+	Hints       []apiHint `json:"hints,omitempty"`
+	Service     string    `json:"service,omitempty"`
+	Instanced   bool      `json:"instanced,omitempty"`
+}
+
+type apiFile struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	URL  string `json:"url"`
+}
+
+type apiHint struct {
+	ID          string `json:"id"`
+	Cost        int    `json:"cost"`
+	Bought      bool   `json:"bought"`
+	Description string `json:"description,omitempty"`
+}
+
+type apiScore struct {
+	Position  int        `json:"pos"`
+	Name      string     `json:"name"`
+	Points    int        `json:"points"`
+	LastSolve *time.Time `json:"lastSolve,omitempty"`
+}
+
+type apiUser struct {
+	Name  string `json:"name"`
+	Team  string `json:"team,omitempty"`
+	Score int    `json:"score"`
+	Admin bool   `json:"admin"`
+}
+
+func apiError(c *fiber.Ctx, status int, err error) error {
+	return c.Status(status).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
+
+// apiStatus maps the errors of the ctf methods to HTTP status codes.
+func apiStatus(err error) int {
+	switch {
+	case errors.Is(err, errNotLoggedIn):
+		return fiber.StatusUnauthorized
+	case errors.Is(err, errNotStarted), errors.Is(err, errEnded), errors.Is(err, errChallengeLocked):
+		return fiber.StatusForbidden
+	case errors.Is(err, errAlreadySolved):
+		return fiber.StatusConflict
+	case errors.Is(err, errUnknownHint):
+		return fiber.StatusNotFound
+	default:
+		return fiber.StatusInternalServerError
+	}
+}
+
+// apiChallengeFromCtx returns the challenge of the challengePath parameter if
+// it exists and is visible to the user.
+func (ctf *ctf) apiChallengeFromCtx(c *fiber.Ctx) (challenge, error) {
+	challenges, err := ctf.currentChallenges(ctf.sessionIsAdmin(c))
+	if err != nil {
+		return challenge{}, err
+	}
+	cha, ok := challenges[c.Params("challengePath")]
+	if !ok {
+		return challenge{}, fiber.ErrNotFound
+	}
+	if !ctf.Configuration.started() {
+		return challenge{}, errNotStarted
+	}
+	if ctf.isLocked(c, c.Params("challengePath")) {
+		return challenge{}, errChallengeLocked
+	}
+	return cha, nil
+}
+
+func rGetAPIChallenges(c *fiber.Ctx, ctf *ctf) error {
+	if !ctf.Configuration.started() {
+		return apiError(c, fiber.StatusForbidden, errNotStarted)
+	}
+
+	challenges, err := ctf.currentChallenges(ctf.sessionIsAdmin(c))
+	if err != nil {
+		return apiError(c, fiber.StatusInternalServerError, err)
+	}
+	solved, err := ctf.solvedChallenges(c)
+	if err != nil {
+		return apiError(c, apiStatus(err), err)
+	}
+	locked := ctf.lockedChallenges(c)
+	counts, err := ctf.solveCounts(ctf.sessionIsAdmin(c))
+	if err != nil {
+		return apiError(c, fiber.StatusInternalServerError, err)
+	}
+
+	list := []apiChallenge{}
+	for id, cha := range challenges {
+		isLocked := slices.Contains(locked, id)
+		if isLocked && !ctf.Configuration.ShowLocked {
+			continue
+		}
+		list = append(list, apiChallenge{
+			ID:       id,
+			Name:     cha.Title,
+			Category: cha.Category,
+			Value:    cha.Points,
+			Solves:   counts[id],
+			Solved:   slices.Contains(solved, id),
+			Locked:   isLocked,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+
+	return c.JSON(list)
+}
+
+func rGetAPIChallenge(c *fiber.Ctx, ctf *ctf) error {
+	cha, err := ctf.apiChallengeFromCtx(c)
+	if errors.Is(err, fiber.ErrNotFound) {
+		return apiError(c, fiber.StatusNotFound, fmt.Errorf("challenge does not exist"))
+	}
+	if err != nil {
+		return apiError(c, apiStatus(err), err)
+	}
+
+	id := c.Params("challengePath")
+	bought := ctf.getHints(c, id)
+	counts, err := ctf.solveCounts(ctf.sessionIsAdmin(c))
+	if err != nil {
+		return apiError(c, fiber.StatusInternalServerError, err)
+	}
+
+	result := apiChallenge{
+		ID:          id,
+		Name:        cha.Title,
+		Category:    cha.Category,
+		Value:       cha.Points,
+		Solves:      counts[id],
+		Solved:      ctf.isSolved(c, id),
+		Description: cha.Text,
+		Files:       []apiFile{},
+		Hints:       []apiHint{},
+	}
+	for fileID, file := range cha.Files {
+		result.Files = append(result.Files, apiFile{
+			Name: file.Filename,
+			Size: file.Size,
+			URL:  fmt.Sprintf("%s/challenges/%s/files/%s", ctf.BasePath, id, fileID),
+		})
+	}
+	for _, hint := range cha.Hints {
+		h := apiHint{ID: hint.UID, Cost: hint.Cost, Bought: slices.Contains(bought, hint.UID)}
+		if h.Bought {
+			h.Description = hint.Text
+		}
+		result.Hints = append(result.Hints, h)
+	}
+	switch {
+	case cha.Service.Instanced:
+		// the port in challenge.yml is the one inside the container, players
+		// only reach their own instance
+		result.Instanced = true
+		if i, running := ctf.instance(c, id); running {
+			result.Service = fmt.Sprintf("%s:%d", ctf.Configuration.ServiceHost, i.Port)
+		}
+	case cha.Service.Port != 0:
+		result.Service = fmt.Sprintf("%s:%d", ctf.Configuration.ServiceHost, cha.Service.Port)
+	}
+
+	return c.JSON(result)
+}
+
+func rPostAPIChallengeFlag(c *fiber.Ctx, ctf *ctf) error {
+	payload := struct {
+		Flag string `json:"flag" form:"flag"`
+	}{}
+
+	if err := c.BodyParser(&payload); err != nil {
+		return apiError(c, fiber.StatusBadRequest, err)
+	}
+
+	_, err := ctf.apiChallengeFromCtx(c)
+	if errors.Is(err, fiber.ErrNotFound) {
+		return apiError(c, fiber.StatusNotFound, fmt.Errorf("challenge does not exist"))
+	}
+	if err != nil {
+		return apiError(c, apiStatus(err), err)
+	}
+
+	if ctf.coolDownActive(c) {
+		return apiError(c, fiber.StatusTooManyRequests,
+			fmt.Errorf("too many false flags, try again in a few seconds"))
+	}
+
+	points, err := ctf.solve(c, payload.Flag)
+	switch {
+	case err == nil:
+		return c.JSON(fiber.Map{"correct": true, "points": points})
+	case errors.Is(err, errWrongFlag):
+		return c.JSON(fiber.Map{"correct": false})
+	default:
+		return apiError(c, apiStatus(err), err)
+	}
+}
+
+func rPostAPIChallengeHint(c *fiber.Ctx, ctf *ctf) error {
+	cha, err := ctf.apiChallengeFromCtx(c)
+	if errors.Is(err, fiber.ErrNotFound) {
+		return apiError(c, fiber.StatusNotFound, fmt.Errorf("challenge does not exist"))
+	}
+	if err != nil {
+		return apiError(c, apiStatus(err), err)
+	}
+
+	if err = ctf.buyHint(c, c.Params("challengePath"), c.Params("hintID")); err != nil {
+		return apiError(c, apiStatus(err), err)
+	}
+
+	for _, hint := range cha.Hints {
+		if hint.UID == c.Params("hintID") {
+			return c.JSON(apiHint{ID: hint.UID, Cost: hint.Cost, Bought: true, Description: hint.Text})
+		}
+	}
+	return apiError(c, fiber.StatusNotFound, errUnknownHint)
+}
+
+func rGetAPIScoreboard(c *fiber.Ctx, ctf *ctf) error {
+	scores, err := ctf.scores(ctf.sessionIsAdmin(c))
+	if err != nil {
+		return apiError(c, fiber.StatusInternalServerError, err)
+	}
+
+	list := []apiScore{}
+	for _, s := range scores {
+		entry := apiScore{Position: s.Position, Name: s.User, Points: s.Points}
+		if !s.LastSolve.IsZero() {
+			lastSolve := s.LastSolve
+			entry.LastSolve = &lastSolve
+		}
+		list = append(list, entry)
+	}
+	return c.JSON(list)
+}
+
+func rGetAPIMe(c *fiber.Ctx, ctf *ctf) error {
+	u, err := ctf.ensureLoggedIn(c)
+	if err != nil {
+		return apiError(c, apiStatus(err), err)
+	}
+
+	name, err := u.name()
+	if err != nil {
+		return apiError(c, fiber.StatusInternalServerError, err)
+	}
+	values, err := ctf.values(ctf.scoreboardUntil(ctf.isAdmin(u)))
+	if err != nil {
+		return apiError(c, fiber.StatusInternalServerError, err)
+	}
+	score, err := u.score(values)
+	if err != nil {
+		return apiError(c, fiber.StatusInternalServerError, err)
+	}
+	team, _ := u.teamName()
+
+	return c.JSON(apiUser{Name: name, Team: team, Score: score, Admin: ctf.isAdmin(u)})
+}
+
+func addAPIRoutes(app *fiber.App, ctf *ctf) {
+	// get scoreboard, public like /score
+	app.Get("/api/v1/scoreboard", ctf.tokenMiddleware, func(c *fiber.Ctx) error {
+		return rGetAPIScoreboard(c, ctf)
+	})
+
+	api := app.Group("/api/v1", ctf.tokenMiddleware, func(c *fiber.Ctx) error {
+		if !ctf.loggedIn(c) {
+			return apiError(c, fiber.StatusUnauthorized, errNotLoggedIn)
+		}
+		return c.Next()
+	})
+
+	// list challenges
+	api.Get("/challenges", func(c *fiber.Ctx) error {
+		return rGetAPIChallenges(c, ctf)
+	})
+
+	// get challenge details
+	api.Get("/challenges/:challengePath", func(c *fiber.Ctx) error {
+		return rGetAPIChallenge(c, ctf)
+	})
+
+	// submit a flag
+	api.Post("/challenges/:challengePath/flag", func(c *fiber.Ctx) error {
+		return rPostAPIChallengeFlag(c, ctf)
+	})
+
+	// "buy" a hint
+	api.Post("/challenges/:challengePath/hints/:hintID", func(c *fiber.Ctx) error {
+		return rPostAPIChallengeHint(c, ctf)
+	})
+
+	// get current user
+	api.Get("/me", func(c *fiber.Ctx) error {
+		return rGetAPIMe(c, ctf)
+	})
+}
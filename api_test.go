@@ -0,0 +1,75 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIAuthentication(t *testing.T) {
+	ctf := newTestCTF(t, "title: test\nsessionTimeout: 600\n", nil)
+
+	app := fiber.New()
+	app.Post("/signup", func(c *fiber.Ctx) error {
+		_, err := ctf.register(c, "alice", "password", "password", "")
+		return err
+	})
+	app.Post("/token", func(c *fiber.Ctx) error {
+		token, err := ctf.createAPIToken(c, "script")
+		if err != nil {
+			return err
+		}
+		return c.SendString(token)
+	})
+	addAPIRoutes(app, ctf)
+
+	resp, err := app.Test(httptest.NewRequest("POST", "/signup", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := resp.Cookies()
+	withSession := func(req *http.Request) {
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+	}
+
+	req := httptest.NewRequest("POST", "/token", nil)
+	withSession(req)
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _ := io.ReadAll(resp.Body)
+
+	for _, test := range []struct {
+		name   string
+		auth   func(req *http.Request)
+		status int
+	}{
+		{"none", func(*http.Request) {}, fiber.StatusUnauthorized},
+		{"session", withSession, fiber.StatusOK},
+		{"token", func(req *http.Request) {
+			req.Header.Set("Authorization", "Bearer "+string(token))
+		}, fiber.StatusOK},
+		{"invalid token", func(req *http.Request) {
+			req.Header.Set("Authorization", "Bearer ctf_invalid")
+		}, fiber.StatusUnauthorized},
+		{"invalid token with session", func(req *http.Request) {
+			withSession(req)
+			req.Header.Set("Authorization", "Bearer ctf_invalid")
+		}, fiber.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest("GET", "/api/v1/me", nil)
+		test.auth(req)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, resp.StatusCode, test.status)
+		}
+	}
+}
@@ -1,15 +1,23 @@
 package main
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"fmt"
 	"os"
+	"strings"
+	"text/template"
 )
 
+// challengeFileTemplateSuffix marks challenge files that are rendered per
+// player with text/template, e.g. to embed dynamic flags.
+const challengeFileTemplateSuffix = ".tmpl"
+
 type challengeFile struct {
 	Filename string
 	Size     int64
 	Location string
+	Template bool
 }
 
 func readChallengeFile(path string) (challengeFile, error) {
@@ -21,10 +29,28 @@ func readChallengeFile(path string) (challengeFile, error) {
 	}
 	chaFi.Size = fi.Size()
 	chaFi.Filename = fi.Name()
+	if strings.HasSuffix(chaFi.Filename, challengeFileTemplateSuffix) {
+		chaFi.Filename = strings.TrimSuffix(chaFi.Filename, challengeFileTemplateSuffix)
+		chaFi.Template = true
+	}
 
 	return chaFi, nil
 }
 
+// render returns the content of a template file for the given flag.
+func (f *challengeFile) render(flag, userName string) ([]byte, error) {
+	tmpl, err := template.ParseFiles(f.Location)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, map[string]string{
+		"Flag": flag,
+		"User": userName,
+	})
+	return buf.Bytes(), err
+}
+
 func readChallengeFiles(path string) map[string]challengeFile {
 	challengeFiles := make(map[string]challengeFile)
 
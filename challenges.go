@@ -4,33 +4,56 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"gopkg.in/yaml.v3"
+	"math"
 	"os"
 	"path/filepath"
+	"slices"
 )
 
 type challengeHint struct {
 	Text string `yaml:"description"`
 	Cost int    `yaml:"cost"`
-	UID  string
+	UID  string `yaml:"-"`
 }
 
+// challengeService is the service of a challenge on Port. Instanced services
+// are not shared, every player can start a private instance that runs for TTL
+// seconds. Port is the port inside their containers then.
 type challengeService struct {
-	Port int `yaml:"port"`
+	Port      int             `yaml:"port"`
+	Instanced bool            `yaml:"instanced"`
+	TTL       int             `yaml:"ttl"`
+	Health    challengeHealth `yaml:"health"`
+}
+
+// challengeScoring configures dynamic scoring. The value of a challenge
+// decays from Initial, which it keeps for the first solve, to Minimum,
+// reaching it after Decay solves.
+type challengeScoring struct {
+	Initial int `yaml:"initial"`
+	Minimum int `yaml:"minimum"`
+	Decay   int `yaml:"decay"`
 }
 
 type challenge struct {
-	Title    string `yaml:"name"`
-	Text     string `yaml:"description"`
-	Points   int    `yaml:"value"`
-	Flag     string `yaml:"flag"`
-	Category string `yaml:"category"`
-	Files    map[string]challengeFile
-	Hints    []challengeHint  `yaml:"hints"`
-	Service  challengeService `yaml:"service"`
+	Title           string                   `yaml:"name"`
+	Text            string                   `yaml:"description"`
+	Points          int                      `yaml:"value"`
+	Flag            challengeFlags           `yaml:"flag"`
+	Category        string                   `yaml:"category"`
+	Files           map[string]challengeFile `yaml:"-"`
+	Hints           []challengeHint          `yaml:"hints"`
+	Service         challengeService         `yaml:"service"`
+	Scoring         challengeScoring         `yaml:"scoring"`
+	Requires        []string                 `yaml:"requires"`
+	FirstBloodBonus firstBloodBonus          `yaml:"firstBloodBonus"`
 }
 
-func readChallenges(path string) (map[string]challenge, error) {
+// readChallenges reads all challenges of the CTF at path. Challenges that
+// cannot be read are left out and their errors are returned by challenge ID.
+func readChallenges(path string) (map[string]challenge, map[string]error) {
 	challenges := make(map[string]challenge)
+	errs := make(map[string]error)
 	challengePath := fmt.Sprintf("%s/challenges/", path)
 
 	items, _ := os.ReadDir(challengePath)
@@ -39,11 +62,13 @@ func readChallenges(path string) (map[string]challenge, error) {
 			cha, err := readChallenge(filepath.Join(challengePath, item.Name()))
 			if err == nil {
 				challenges[item.Name()] = cha
+			} else {
+				errs[item.Name()] = err
 			}
 		}
 	}
 
-	return challenges, nil
+	return challenges, errs
 }
 
 func readChallenge(path string) (challenge, error) {
@@ -65,10 +90,48 @@ func readChallenge(path string) (challenge, error) {
 		cha.Hints[i].UID = fmt.Sprintf("DINO%x", hash.Sum(nil))
 	}
 
+	if cha.Scoring.Decay > 0 && cha.Scoring.Initial == 0 {
+		cha.Scoring.Initial = cha.Points
+	}
+
 	cha.Files = readChallengeFiles(fmt.Sprintf("%s/files/", path))
 	return cha, nil
 }
 
+func (c *challenge) dynamic() bool {
+	return c.Scoring.Decay > 0
+}
+
+// value returns the points a challenge is worth after it has been solved
+// the given number of times. The first solver gets the initial value.
+func (c *challenge) value(solves int) int {
+	if !c.dynamic() {
+		return c.Points
+	}
+	s := c.Scoring
+	if solves >= s.Decay {
+		return s.Minimum
+	}
+	if solves <= 1 {
+		return s.Initial
+	}
+	// quadratic decay between the first and the Decay-th solve
+	progress := float64(solves-1) / float64(s.Decay-1)
+	value := float64(s.Minimum-s.Initial)*progress*progress + float64(s.Initial)
+	return max(int(math.Ceil(value)), s.Minimum)
+}
+
+// unlocked reports whether all challenges required by c are contained in
+// solved.
+func (c *challenge) unlocked(solved []string) bool {
+	for _, required := range c.Requires {
+		if !slices.Contains(solved, required) {
+			return false
+		}
+	}
+	return true
+}
+
 func (c *challenge) print() {
 	fmt.Printf("%s:%s (%d)", c.Title, c.Text, c.Points)
 }
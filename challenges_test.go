@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestChallengeValue(t *testing.T) {
+	dynamic := challenge{Points: 500, Scoring: challengeScoring{Initial: 500, Minimum: 100, Decay: 5}}
+	single := challenge{Points: 500, Scoring: challengeScoring{Initial: 500, Minimum: 100, Decay: 1}}
+	static := challenge{Points: 50}
+
+	tests := []struct {
+		name      string
+		challenge challenge
+		solves    int
+		want      int
+	}{
+		{"unsolved", dynamic, 0, 500},
+		{"first solve keeps initial", dynamic, 1, 500},
+		{"second solve", dynamic, 2, 475},
+		{"midpoint", dynamic, 3, 400},
+		{"rounded up", dynamic, 4, 275},
+		{"minimum after decay solves", dynamic, 5, 100},
+		{"clamped after decay", dynamic, 6, 100},
+		{"clamped far after decay", dynamic, 1000, 100},
+		{"decay of one unsolved", single, 0, 500},
+		{"decay of one", single, 1, 100},
+		{"static", static, 0, 50},
+		{"static solved", static, 10, 50},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.challenge.value(test.solves); got != test.want {
+				t.Errorf("value(%d) = %d, want %d", test.solves, got, test.want)
+			}
+		})
+	}
+}
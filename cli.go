@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const usage = `usage: ctfEngine <command> [-l <ctf directory>] [arguments]
+
+commands:
+  serve                                  run the web server (default)
+  token add <token>...                   add signup tokens
+  token list                             list unused signup tokens
+  token revoke <token>...                delete signup tokens
+  token generate [count]                 add and print random signup tokens
+  user create [-admin] [-password-stdin] <name>
+                                         add a user
+  user reset-password [-password-stdin] <name>
+                                         set a new password
+  user set-admin <name> [true|false]     grant or revoke admin rights
+  user delete <name>                     remove a user and its solves
+  export [-o <file>]                     write the database as JSON
+  import [-replace] <file>               read a database export
+  lint                                   check the ctf for mistakes
+
+Users get a random password unless -password-stdin is given.
+`
+
+// runCommand runs the subcommand in args and returns the exit code. Without
+// a subcommand the web server is started.
+func runCommand(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return runServe(args)
+	}
+
+	switch args[0] {
+	case "serve":
+		return runServe(args[1:])
+	case "token":
+		return runToken(args[1:])
+	case "user":
+		return runUser(args[1:])
+	case "export":
+		return runExport(args[1:])
+	case "import":
+		return runImport(args[1:])
+	case "lint":
+		return runLint(args[1:])
+	case "help":
+		fmt.Print(usage)
+		return 0
+	default:
+		fmt.Printf("unknown command \"%s\"\n\n%s", args[0], usage)
+		return 2
+	}
+}
+
+// commandFlags returns the flags of a command, which all accept the ctf
+// location.
+func commandFlags(name string) (*flag.FlagSet, *string) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	ctfLocation := flags.String("l", "/ctf", "load ctf from this directory")
+	return flags, ctfLocation
+}
+
+// loadCTF loads the ctf for a command and reports errors.
+func loadCTF(ctfLocation string) (ctf, bool) {
+	ctf, err := initCTF(ctfLocation)
+	if err != nil {
+		fmt.Printf("Could not load CTF from path \"%s\": %s.\n", ctfLocation, err)
+		return ctf, false
+	}
+	return ctf, true
+}
+
+// subcommand splits the action of the token and user commands from args.
+func subcommand(args []string) (string, []string) {
+	if len(args) == 0 {
+		return "", nil
+	}
+	return args[0], args[1:]
+}
+
+func runToken(args []string) int {
+	action, args := subcommand(args)
+	flags, ctfLocation := commandFlags("token " + action)
+	_ = flags.Parse(args)
+
+	arguments := flags.Args()
+	switch action {
+	case "add", "revoke":
+		if len(arguments) == 0 {
+			fmt.Printf("usage: ctfEngine token %s [-l <ctf directory>] <token>...\n", action)
+			return 2
+		}
+	case "generate":
+		if len(arguments) > 1 {
+			fmt.Println("usage: ctfEngine token generate [-l <ctf directory>] [count]")
+			return 2
+		}
+	case "list":
+	default:
+		fmt.Print(usage)
+		return 2
+	}
+
+	ctf, ok := loadCTF(*ctfLocation)
+	if !ok {
+		return 1
+	}
+
+	switch action {
+	case "add":
+		for _, token := range arguments {
+			if err := ctf.addSignupToken(token); err != nil {
+				fmt.Printf("could not add signup token \"%s\": %s\n", token, err)
+				return 1
+			}
+			fmt.Printf("added signup token \"%s\" to DB\n", token)
+		}
+	case "list":
+		tokens, err := dbGetSignupTokens(ctf.Storage)
+		if err != nil {
+			fmt.Printf("could not list signup tokens: %s\n", err)
+			return 1
+		}
+		for _, token := range tokens {
+			fmt.Println(token)
+		}
+	case "revoke":
+		for _, token := range arguments {
+			if exists, _ := dbHasSignupToken(ctf.Storage, token); !exists {
+				fmt.Printf("signup token \"%s\" does not exist\n", token)
+				return 1
+			}
+			if err := dbDeleteSignupToken(ctf.Storage, token); err != nil {
+				fmt.Printf("could not revoke signup token \"%s\": %s\n", token, err)
+				return 1
+			}
+			fmt.Printf("revoked signup token \"%s\"\n", token)
+		}
+	case "generate":
+		count := 1
+		if len(arguments) == 1 {
+			var err error
+			if count, err = strconv.Atoi(arguments[0]); err != nil || count < 1 {
+				fmt.Printf("invalid count \"%s\"\n", arguments[0])
+				return 2
+			}
+		}
+		for i := 0; i < count; i++ {
+			token := uuid.New().String()
+			if err := ctf.addSignupToken(token); err != nil {
+				fmt.Printf("could not add signup token: %s\n", err)
+				return 1
+			}
+			fmt.Println(token)
+		}
+	}
+	return 0
+}
+
+// readPassword returns the first line of stdin.
+func readPassword() (string, error) {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", fmt.Errorf("empty password cannot be used")
+	}
+	return password, nil
+}
+
+// newPassword reads the password from stdin if requested or generates one.
+func newPassword(fromStdin bool) (string, bool, error) {
+	if fromStdin {
+		password, err := readPassword()
+		return password, false, err
+	}
+	password, err := generatePassword()
+	return password, true, err
+}
+
+func runUser(args []string) int {
+	action, args := subcommand(args)
+	flags, ctfLocation := commandFlags("user " + action)
+	admin := flags.Bool("admin", false, "grant admin rights to the new user")
+	passwordStdin := flags.Bool("password-stdin", false, "read the password from stdin")
+	_ = flags.Parse(args)
+
+	arguments := flags.Args()
+	switch action {
+	case "create", "reset-password", "delete":
+		if len(arguments) != 1 {
+			fmt.Printf("usage: ctfEngine user %s [-l <ctf directory>] <name>\n", action)
+			return 2
+		}
+	case "set-admin":
+		if len(arguments) != 1 && len(arguments) != 2 {
+			fmt.Println("usage: ctfEngine user set-admin [-l <ctf directory>] <name> [true|false]")
+			return 2
+		}
+	default:
+		fmt.Print(usage)
+		return 2
+	}
+	username := arguments[0]
+
+	ctf, ok := loadCTF(*ctfLocation)
+	if !ok {
+		return 1
+	}
+
+	switch action {
+	case "create":
+		password, generated, err := newPassword(*passwordStdin)
+		if err != nil {
+			fmt.Printf("could not create user: %s\n", err)
+			return 1
+		}
+		if _, err := ctf.createUser(username, password); err != nil {
+			fmt.Printf("could not create user: %s\n", err)
+			return 1
+		}
+		if *admin {
+			if err := ctf.setAdmin(username, true); err != nil {
+				fmt.Printf("could not grant admin rights: %s\n", err)
+				return 1
+			}
+		}
+		fmt.Printf("created user \"%s\"\n", username)
+		if generated {
+			fmt.Printf("password: %s\n", password)
+		}
+	case "reset-password":
+		id, err := dbUserGetID(ctf.Storage, username)
+		if err != nil {
+			fmt.Printf("user \"%s\" does not exist\n", username)
+			return 1
+		}
+		password, generated, err := newPassword(*passwordStdin)
+		if err == nil {
+			err = ctf.resetPassword(id, password)
+		}
+		if err != nil {
+			fmt.Printf("could not reset password: %s\n", err)
+			return 1
+		}
+		fmt.Printf("reset password of \"%s\"\n", username)
+		if generated {
+			fmt.Printf("password: %s\n", password)
+		}
+	case "set-admin":
+		grant := true
+		if len(arguments) == 2 {
+			var err error
+			if grant, err = strconv.ParseBool(arguments[1]); err != nil {
+				fmt.Printf("invalid value \"%s\", use true or false\n", arguments[1])
+				return 2
+			}
+		}
+		if err := ctf.setAdmin(username, grant); err != nil {
+			fmt.Printf("could not change admin rights: %s\n", err)
+			return 1
+		}
+		if grant {
+			fmt.Printf("granted admin rights to \"%s\"\n", username)
+		} else {
+			fmt.Printf("revoked admin rights of \"%s\"\n", username)
+		}
+	case "delete":
+		if err := ctf.deleteUser(username); err != nil {
+			fmt.Printf("could not delete user: %s\n", err)
+			return 1
+		}
+		fmt.Printf("deleted user \"%s\"\n", username)
+	}
+	return 0
+}
+
+func runExport(args []string) int {
+	flags, ctfLocation := commandFlags("export")
+	output := flags.String("o", "-", "write the export to this file")
+	_ = flags.Parse(args)
+
+	ctf, ok := loadCTF(*ctfLocation)
+	if !ok {
+		return 1
+	}
+
+	export, err := ctf.exportDB()
+	if err != nil {
+		fmt.Printf("could not export: %s\n", err)
+		return 1
+	}
+
+	out := os.Stdout
+	if *output != "-" {
+		if out, err = os.Create(*output); err != nil {
+			fmt.Printf("could not export: %s\n", err)
+			return 1
+		}
+		defer func() {
+			_ = out.Close()
+		}()
+	}
+	if err := writeExport(out, export); err != nil {
+		fmt.Printf("could not export: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+func runImport(args []string) int {
+	flags, ctfLocation := commandFlags("import")
+	replace := flags.Bool("replace", false, "replace the existing rows of the imported tables")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Println("usage: ctfEngine import [-l <ctf directory>] [-replace] <file>")
+		return 2
+	}
+
+	in := os.Stdin
+	if flags.Arg(0) != "-" {
+		var err error
+		if in, err = os.Open(flags.Arg(0)); err != nil {
+			fmt.Printf("could not import: %s\n", err)
+			return 1
+		}
+		defer func() {
+			_ = in.Close()
+		}()
+	}
+
+	export, err := readExport(in)
+	if err != nil {
+		fmt.Printf("could not import: %s\n", err)
+		return 1
+	}
+
+	ctf, ok := loadCTF(*ctfLocation)
+	if !ok {
+		return 1
+	}
+
+	if err := ctf.importDB(export, *replace); err != nil {
+		fmt.Printf("could not import: %s\n", err)
+		return 1
+	}
+	fmt.Printf("imported %d tables\n", len(export.Tables))
+	return 0
+}
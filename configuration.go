@@ -6,16 +6,45 @@ import (
 	"gopkg.in/yaml.v3"
 	"html/template"
 	"os"
+	"time"
 )
 
 type configuration struct {
-	Title             string `yaml:"title"`
-	Contact           string `yaml:"contact"`
-	SessionTimeout    int    `yaml:"sessionTimeout"`
-	CoolDown          int    `yaml:"submitCoolDown"`
-	ServiceHost       string `yaml:"serviceHost"`
-	RegistrationToken bool   `yaml:"registrationToken"`
-	IndexPage         template.HTML
+	Title             string          `yaml:"title"`
+	Contact           string          `yaml:"contact"`
+	SessionTimeout    int             `yaml:"sessionTimeout"`
+	CoolDown          int             `yaml:"submitCoolDown"`
+	ServiceHost       string          `yaml:"serviceHost"`
+	RegistrationToken bool            `yaml:"registrationToken"`
+	Teams             bool            `yaml:"teams"`
+	TeamSize          int             `yaml:"teamSize"`
+	ShowLocked        bool            `yaml:"showLockedChallenges"`
+	Start             time.Time       `yaml:"start"`
+	End               time.Time       `yaml:"end"`
+	Freeze            time.Time       `yaml:"freeze"`
+	Admins            []string        `yaml:"admins"`
+	FlagSecret        string          `yaml:"flagSecret"`
+	FirstBloodBonus   firstBloodBonus `yaml:"firstBloodBonus"`
+	ServiceRuntime    string          `yaml:"serviceRuntime"`
+	InstancePorts     []int           `yaml:"instancePorts"`
+	Listen            string          `yaml:"listen"`
+	TLSCert           string          `yaml:"tlsCert"`
+	TLSKey            string          `yaml:"tlsKey"`
+	BasePath          string          `yaml:"basePath"`
+	TrustedProxies    []string        `yaml:"trustedProxies"`
+	IndexPage         template.HTML   `yaml:"-"`
+}
+
+func (conf *configuration) started() bool {
+	return conf.Start.IsZero() || time.Now().After(conf.Start)
+}
+
+func (conf *configuration) ended() bool {
+	return !conf.End.IsZero() && time.Now().After(conf.End)
+}
+
+func (conf *configuration) frozen() bool {
+	return !conf.Freeze.IsZero() && time.Now().After(conf.Freeze)
 }
 
 func readConfiguration(filePath string) (configuration, error) {
@@ -1,27 +1,52 @@
 package main
 
 import (
-	"crypto/sha256"
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/session"
-	"math/rand"
 	"slices"
-	"strconv"
-	"strings"
+	"sort"
+	"sync"
 	"time"
 )
 
+var (
+	errNotLoggedIn     = errors.New("user not logged in")
+	errNotStarted      = errors.New("the CTF has not started yet")
+	errEnded           = errors.New("the CTF is over")
+	errChallengeLocked = errors.New("challenge is locked")
+	errAlreadySolved   = errors.New("challenge already solved")
+	errWrongFlag       = errors.New("submitted flag was wrong")
+	errUnknownHint     = errors.New("hint does not exist")
+)
+
 type ctf struct {
 	Storage       *sql.DB
 	Challenges    map[string]challenge
 	Sessions      *session.Store
 	Configuration configuration
+	Location      string
+	// BasePath is the path the ctf is served below, e.g. "/ctf" or ""
+	BasePath string
+	// TrustedProxies may set the client IP by X-Forwarded-For
+	TrustedProxies proxyNetworks
+	Events         *eventHub
+	// Services is nil unless serviceRuntime is configured
+	Services *serviceManager
+	Health   *healthChecker
+	// lock guards Challenges and Configuration against reloads, requests
+	// hold it for reading
+	lock *sync.RWMutex
 }
 
 func initCTF(path string) (ctf, error) {
 	var ctf ctf
+	ctf.Location = path
+	ctf.lock = &sync.RWMutex{}
+	ctf.Events = newEventHub()
+	ctf.Health = newHealthChecker()
 
 	configuration, err := readConfiguration(path)
 	if err != nil {
@@ -37,9 +62,27 @@ func initCTF(path string) (ctf, error) {
 	}
 	ctf.Storage = sessionStorage.Conn()
 
-	challenges, _ := readChallenges(path)
+	if ctf.Configuration.FlagSecret == "" {
+		ctf.Configuration.FlagSecret, err = initFlagSecret(ctf.Storage)
+		if err != nil {
+			return ctf, err
+		}
+	}
+
+	challenges, errs := readChallenges(path)
+	for id, err := range errs {
+		fmt.Printf("[ERROR] could not load challenge \"%s\": %s!\n", id, err)
+	}
 	ctf.Challenges = challenges
 
+	if ctf.Configuration.ServiceRuntime != "" {
+		runtime, err := newServiceRuntime(ctf.Configuration.ServiceRuntime)
+		if err != nil {
+			return ctf, err
+		}
+		ctf.Services = newServiceManager(runtime)
+	}
+
 	sessions := session.New(session.Config{
 		Storage:    sessionStorage,
 		Expiration: time.Second * time.Duration(ctf.Configuration.SessionTimeout),
@@ -71,6 +114,11 @@ func (ctf *ctf) register(c *fiber.Ctx, username, password, password2, token stri
 		return user{}, fmt.Errorf("passwords does not match")
 	}
 
+	// admins of ctf.yml are promoted on startup, so their names are reserved
+	if slices.Contains(ctf.Configuration.Admins, username) {
+		return user{}, fmt.Errorf("username is reserved")
+	}
+
 	if ctf.Configuration.RegistrationToken {
 		state, err := dbHasSignupToken(ctf.Storage, token)
 		if err != nil {
@@ -81,10 +129,7 @@ func (ctf *ctf) register(c *fiber.Ctx, username, password, password2, token stri
 		}
 	}
 
-	salt := strconv.Itoa(rand.New(rand.NewSource(time.Now().UnixNano())).Int())
-	hash := sha256.Sum256([]byte(password + salt))
-
-	id, err := dbUserRegister(ctf.Storage, username, string(hash[:]), salt)
+	id, err := ctf.createUser(username, password)
 	if ctf.Configuration.RegistrationToken {
 		_ = dbDeleteSignupToken(ctf.Storage, token)
 	}
@@ -101,9 +146,37 @@ func (ctf *ctf) register(c *fiber.Ctx, username, password, password2, token stri
 	return sessionUser, nil
 }
 
+// createUser adds a user and returns its id.
+func (ctf *ctf) createUser(username, password string) (int, error) {
+	if username == "" {
+		return 0, fmt.Errorf("empty username cannot be used")
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := dbUserRegister(ctf.Storage, username, hash)
+	if err != nil {
+		return 0, err
+	}
+
+	// earlier announcements are listed on /announcements, but not as toasts
+	if latest, err := dbGetLatestAnnouncementID(ctf.Storage); err == nil {
+		_ = dbUserSetAnnouncement(ctf.Storage, id, latest)
+	}
+
+	return id, nil
+}
+
 type score struct {
-	User   string
-	Points int
+	Position    int
+	Player      string
+	User        string
+	Points      int
+	LastSolve   time.Time
+	FirstBloods int
 }
 
 func (ctf *ctf) categories() ([]string, error) {
@@ -118,21 +191,157 @@ func (ctf *ctf) categories() ([]string, error) {
 	return categories, nil
 }
 
-func (ctf *ctf) scores() ([]score, error) {
+// values returns the values of all dynamically scored challenges considering
+// only solves before until. The zero time means all solves.
+func (ctf *ctf) values(until time.Time) (map[string]int, error) {
+	values := make(map[string]int)
+
+	// hidden users such as organizers testing challenges do not lower values
+	counts, err := dbChallengeGetVisibleSolveCounts(ctf.Storage, until)
+	if err != nil {
+		return values, err
+	}
+
+	for id, c := range ctf.Challenges {
+		if c.dynamic() {
+			values[id] = c.value(counts[id])
+		}
+	}
+	return values, nil
+}
+
+// currentChallenges returns the challenges with the points set to their
+// current value. Unless live is set, dynamic values stay at their value at
+// the scoreboard freeze, as their decay would reveal later solves.
+func (ctf *ctf) currentChallenges(live bool) (map[string]challenge, error) {
+	values, err := ctf.values(ctf.scoreboardUntil(live))
+	if err != nil {
+		return nil, err
+	}
+
+	challenges := make(map[string]challenge, len(ctf.Challenges))
+	for id, c := range ctf.Challenges {
+		if value, ok := values[id]; ok {
+			c.Points = value
+		}
+		challenges[id] = c
+	}
+	return challenges, nil
+}
+
+// scoreboardUntil returns the time after which solves are not shown on the
+// scoreboard, the zero time if all solves are shown.
+func (ctf *ctf) scoreboardUntil(live bool) time.Time {
+	if !live && ctf.Configuration.frozen() {
+		return ctf.Configuration.Freeze
+	}
+	return time.Time{}
+}
+
+// rankScores sorts scores by points. Ties are broken by the time of the last
+// solve, i.e. the player who reached the score first is ranked higher, and
+// players without solves come last. Players with the same points and time of
+// the last solve share their position and are ordered by name.
+func rankScores(scores []score) {
+	sort.SliceStable(scores, func(i, j int) bool {
+		a, b := scores[i], scores[j]
+		switch {
+		case a.Points != b.Points:
+			return a.Points > b.Points
+		case !a.LastSolve.Equal(b.LastSolve):
+			// players without solves are ranked last
+			return b.LastSolve.IsZero() || !a.LastSolve.IsZero() && a.LastSolve.Before(b.LastSolve)
+		default:
+			return a.User < b.User
+		}
+	})
+
+	for i := range scores {
+		scores[i].Position = i + 1
+		if i > 0 && scores[i].Points == scores[i-1].Points && scores[i].LastSolve.Equal(scores[i-1].LastSolve) {
+			scores[i].Position = scores[i-1].Position
+		}
+	}
+}
+
+// scores returns the scoreboard ranked by rankScores. Unless live is set,
+// solves after the configured freeze are not taken into account.
+func (ctf *ctf) scores(live bool) ([]score, error) {
 	var scores []score
 
-	rows, err := dbGetScoreboard(ctf.Storage)
+	until := ctf.scoreboardUntil(live)
+	values, err := ctf.values(until)
 	if err != nil {
 		return nil, err
 	}
 
+	getScoreboard := dbGetScoreboard
+	if ctf.Configuration.Teams {
+		getScoreboard = dbGetTeamScoreboard
+	}
+
+	rows, err := getScoreboard(ctf.Storage, values, until)
+	if err != nil {
+		return nil, err
+	}
+
+	solves, err := dbGetSolves(ctf.Storage, values, until, ctf.Configuration.Teams)
+	if err != nil {
+		return nil, err
+	}
+	lastSolve := make(map[string]time.Time)
+	firstBloods := make(map[string]int)
+	for _, row := range solves {
+		lastSolve[row[0].(string)] = row[3].(time.Time)
+		if row[4].(int) == 1 {
+			firstBloods[row[0].(string)]++
+		}
+	}
+
 	for _, row := range rows {
-		scores = append(scores, score{User: row[1].(string), Points: row[2].(int)})
+		scores = append(scores, score{
+			Player:      row[0].(string),
+			User:        row[1].(string),
+			Points:      row[2].(int),
+			LastSolve:   lastSolve[row[0].(string)],
+			FirstBloods: firstBloods[row[0].(string)],
+		})
 	}
 
+	rankScores(scores)
+
 	return scores, nil
 }
 
+type solver struct {
+	Name string
+	Time time.Time
+}
+
+// solveCounts returns the number of solves per challenge as shown to the
+// players. Unless live is set, solves after the configured freeze are not
+// taken into account.
+func (ctf *ctf) solveCounts(live bool) (map[string]int, error) {
+	return dbChallengeGetVisibleSolveCounts(ctf.Storage, ctf.scoreboardUntil(live))
+}
+
+// solvers returns the players who solved a challenge in the order of their
+// solves. Unless live is set, solves after the configured freeze are not
+// taken into account.
+func (ctf *ctf) solvers(challengeID string, live bool) ([]solver, error) {
+	var solvers []solver
+
+	rows, err := dbChallengeGetSolvers(ctf.Storage, challengeID, ctf.scoreboardUntil(live), ctf.Configuration.Teams)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		solvers = append(solvers, solver{Name: row[0].(string), Time: row[1].(time.Time)})
+	}
+	return solvers, nil
+}
+
 func (ctf *ctf) session(c *fiber.Ctx) (map[string]interface{}, error) {
 	sess, err := ctf.Sessions.Get(c)
 	if err != nil {
@@ -140,6 +349,7 @@ func (ctf *ctf) session(c *fiber.Ctx) (map[string]interface{}, error) {
 	}
 
 	var userName string
+	var teamName string
 	var score int
 	test := sess.Get("user")
 	if test != nil {
@@ -148,10 +358,17 @@ func (ctf *ctf) session(c *fiber.Ctx) (map[string]interface{}, error) {
 		if err != nil {
 			return nil, err
 		}
-		score, err = (&user).score()
+		values, err := ctf.values(ctf.scoreboardUntil(ctf.isAdmin(user)))
+		if err != nil {
+			return nil, err
+		}
+		score, err = (&user).score(values)
 		if err != nil {
 			return nil, err
 		}
+		if ctf.Configuration.Teams {
+			teamName, _ = (&user).teamName()
+		}
 	}
 
 	sess.SetExpiry(time.Second * time.Duration(ctf.Configuration.SessionTimeout))
@@ -168,6 +385,8 @@ func (ctf *ctf) session(c *fiber.Ctx) (map[string]interface{}, error) {
 		"Session":      sess,
 		"LoggedIn":     ctf.loggedIn(c),
 		"UserName":     userName,
+		"TeamName":     teamName,
+		"Admin":        ctf.sessionIsAdmin(c),
 		"Score":        score,
 		"session-user": sess.Get("user"),
 		"Toasts":       toasts,
@@ -175,6 +394,11 @@ func (ctf *ctf) session(c *fiber.Ctx) (map[string]interface{}, error) {
 }
 
 func (ctf *ctf) ensureLoggedIn(c *fiber.Ctx) (user, error) {
+	// set by tokenMiddleware for requests authenticated by an API token
+	if id, ok := c.Locals("user").(int); ok {
+		return user{id: id, db: ctf.Storage}, nil
+	}
+
 	sess, err := ctf.Sessions.Get(c)
 	if err != nil {
 		return user{}, err
@@ -186,7 +410,7 @@ func (ctf *ctf) ensureLoggedIn(c *fiber.Ctx) (user, error) {
 			return sessionUser, nil
 		}
 	}
-	return user{}, fmt.Errorf("user not logged in")
+	return user{}, errNotLoggedIn
 }
 
 func (ctf *ctf) loggedIn(c *fiber.Ctx) bool {
@@ -201,102 +425,242 @@ func (ctf *ctf) addSignupToken(token string) error {
 	return dbInsertSignupToken(ctf.Storage, token)
 }
 
+// running returns an error if the CTF has not started yet or is already over.
+func (ctf *ctf) running() error {
+	if !ctf.Configuration.started() {
+		return errNotStarted
+	}
+	if ctf.Configuration.ended() {
+		return errEnded
+	}
+	return nil
+}
+
 func (ctf *ctf) solve(c *fiber.Ctx, flag string) (int, error) {
 	user, err := ctf.ensureLoggedIn(c)
 	if err != nil {
 		return 0, err
 	}
 
+	if err = ctf.running(); err != nil {
+		return 0, err
+	}
+
 	challenge := ctf.Challenges[c.Params("challengePath")]
 
-	if strings.Compare(strings.TrimSpace(challenge.Flag), strings.TrimSpace(flag)) == 0 {
-		hintCost, err := dbHintGetCost(ctf.Storage, user.id, c.Params("challengePath"))
+	if ctf.isLocked(c, c.Params("challengePath")) {
+		return 0, errChallengeLocked
+	}
+
+	expand, err := ctf.flagExpander(user, c.Params("challengePath"))
+	if err != nil {
+		return 0, err
+	}
+	correct := challenge.Flag.matches(flag, expand)
+	err = dbInsertSubmission(ctf.Storage, user.id, c.Params("challengePath"), flag, correct, ctf.clientIP(c))
+	if err != nil {
+		fmt.Printf("[ERROR] could not log submission: %s!\n", err)
+	}
+	if !correct && challenge.Flag.dynamic() {
+		ctf.detectSharing(user, c.Params("challengePath"), flag)
+	}
+
+	if correct {
+		if ctf.isSolved(c, c.Params("challengePath")) {
+			return 0, errAlreadySolved
+		}
+		members, err := user.memberIDs()
+		if err != nil {
+			return 0, err
+		}
+		hintCost, err := dbHintGetCost(ctf.Storage, members, c.Params("challengePath"))
+		if err != nil {
+			return 0, err
+		}
+		counts, err := dbChallengeGetVisibleSolveCounts(ctf.Storage, time.Time{})
 		if err != nil {
 			return 0, err
 		}
-		points := challenge.Points - hintCost
-		err = dbChallengeAddSolve(ctf.Storage, user.id, c.Params("challengePath"), points)
+		hidden, err := dbUserIsHidden(ctf.Storage, user.id)
 		if err != nil {
 			return 0, err
 		}
-		return points, nil
+		solves := counts[c.Params("challengePath")]
+		if !hidden {
+			solves++
+		}
+		points := challenge.value(solves) - hintCost
+		blood, bonus, err := dbChallengeAddSolve(ctf.Storage, user.id, c.Params("challengePath"), points, hintCost,
+			ctf.firstBloodBonus(c.Params("challengePath")))
+		if errors.Is(err, sql.ErrNoRows) {
+			// a team member solved it concurrently
+			return 0, errAlreadySolved
+		}
+		if err != nil {
+			return 0, err
+		}
+		ctf.publishSolve(user, c.Params("challengePath"), blood)
+
+		// during the freeze the decayed value and the first blood rank would
+		// reveal how many solved the challenge since, so players are told the
+		// value at the freeze instead
+		if until := ctf.scoreboardUntil(ctf.isAdmin(user)); !until.IsZero() {
+			frozen, err := dbChallengeGetVisibleSolveCounts(ctf.Storage, until)
+			if err != nil {
+				return 0, err
+			}
+			solves = frozen[c.Params("challengePath")]
+			if !hidden {
+				solves++
+			}
+			return challenge.value(solves) - hintCost, nil
+		}
+		return points + bonus, nil
 	}
 
-	ctf.failedTriesAdd(c)
-	return 0, fmt.Errorf("submitted flag was wrong")
+	return 0, errWrongFlag
 }
 
-func (ctf *ctf) solvedChallenges(c *fiber.Ctx) ([]string, error) {
-	user, err := ctf.ensureLoggedIn(c)
+// flagExpander returns the expander for the dynamic flags of u.
+func (ctf *ctf) flagExpander(u user, challengeID string) (flagExpander, error) {
+	player, err := u.playerKey()
 	if err != nil {
-		return []string{}, err
+		return nil, err
 	}
-
-	return dbChallengeGetSolved(ctf.Storage, user.id)
+	return func(template string) string {
+		return dynamicFlag(ctf.Configuration.FlagSecret, challengeID, player, template)
+	}, nil
 }
 
-func (ctf *ctf) failedTriesAdd(c *fiber.Ctx) {
-	sess, err := ctf.Sessions.Get(c)
+// detectSharing records a suspected flag sharing if a wrong flag submitted by
+// u is the dynamic flag of another player.
+func (ctf *ctf) detectSharing(u user, challengeID, flag string) {
+	own, err := u.playerKey()
+	if err != nil {
+		return
+	}
+	players, err := dbGetPlayers(ctf.Storage)
 	if err != nil {
 		return
 	}
 
-	var tries []int64
-	value := sess.Get("tries")
-	if value != nil {
-		tries = value.([]int64)
+	challenge := ctf.Challenges[challengeID]
+	for _, player := range players {
+		key := player[0].(string)
+		if key == own {
+			continue
+		}
+		expand := func(template string) string {
+			return dynamicFlag(ctf.Configuration.FlagSecret, challengeID, key, template)
+		}
+		if challenge.Flag.matches(flag, expand) {
+			err = dbInsertSharing(ctf.Storage, u.id, challengeID, player[1].(string), flag)
+			if err != nil {
+				fmt.Printf("[ERROR] could not log flag sharing: %s!\n", err)
+			}
+			return
+		}
 	}
+}
 
-	tries = append(tries, time.Now().UnixNano())
-	_ = ctf.setSessionKey(c, "tries", tries)
+// renderChallengeFile renders a template challenge file for the session user.
+func (ctf *ctf) renderChallengeFile(c *fiber.Ctx, challengeID string, file challengeFile) ([]byte, error) {
+	u, err := ctf.ensureLoggedIn(c)
+	if err != nil {
+		return nil, err
+	}
+	expand, err := ctf.flagExpander(u, challengeID)
+	if err != nil {
+		return nil, err
+	}
+	name, err := u.name()
+	if err != nil {
+		return nil, err
+	}
+	challenge := ctf.Challenges[challengeID]
+	return file.render(challenge.Flag.first(expand), name)
 }
 
-func (ctf *ctf) coolDownActive(c *fiber.Ctx) bool {
-	sess, err := ctf.Sessions.Get(c)
+func (ctf *ctf) solvedChallenges(c *fiber.Ctx) ([]string, error) {
+	user, err := ctf.ensureLoggedIn(c)
 	if err != nil {
-		return true
+		return []string{}, err
 	}
-	value := sess.Get("tries")
-	if value == nil {
-		return false
+
+	members, err := user.memberIDs()
+	if err != nil {
+		return []string{}, err
+	}
+
+	return dbChallengeGetSolved(ctf.Storage, members)
+}
+
+// lockedChallenges returns the IDs of all challenges whose prerequisites are
+// not solved yet by the session user.
+func (ctf *ctf) lockedChallenges(c *fiber.Ctx) []string {
+	var locked []string
+
+	solved, err := ctf.solvedChallenges(c)
+	if err != nil {
+		solved = []string{}
 	}
-	savedTries := value.([]int64)
-	var tries []int64
 
-	for _, attempt := range savedTries {
-		attemptTime := time.Unix(0, attempt)
-		passed := time.Now().Sub(attemptTime).Seconds()
-		if passed < float64(ctf.Configuration.CoolDown) {
-			tries = append(tries, attempt)
+	for id, challenge := range ctf.Challenges {
+		if !challenge.unlocked(solved) {
+			locked = append(locked, id)
 		}
 	}
+	return locked
+}
+
+func (ctf *ctf) isLocked(c *fiber.Ctx, challengeID string) bool {
+	return slices.Contains(ctf.lockedChallenges(c), challengeID)
+}
 
-	tries = append(tries, time.Now().UnixNano())
-	_ = ctf.setSessionKey(c, "tries", tries)
+// coolDownMaxTries is the number of wrong flags a user can submit within the
+// configured cool down before further submissions are rejected.
+const coolDownMaxTries = 3
 
-	if len(tries) > 3+1 {
+// coolDownActive reports whether the session user submitted too many wrong
+// flags within the cool down.
+func (ctf *ctf) coolDownActive(c *fiber.Ctx) bool {
+	u, err := ctf.ensureLoggedIn(c)
+	if err != nil {
 		return true
 	}
 
-	return false
+	since := time.Now().Add(-time.Second * time.Duration(ctf.Configuration.CoolDown))
+	tries, err := dbSubmissionCountWrong(ctf.Storage, u.id, since)
+	if err != nil {
+		return true
+	}
+
+	return tries >= coolDownMaxTries
 }
 
 func (ctf *ctf) buyHint(c *fiber.Ctx, challengeID, hintID string) error {
-	sess, err := ctf.Sessions.Get(c)
+	user, err := ctf.ensureLoggedIn(c)
 	if err != nil {
 		return err
 	}
 
-	user := sess.Get("user")
-	if user == nil {
-		return fmt.Errorf("user not logged in")
+	if err = ctf.running(); err != nil {
+		return err
+	}
+
+	if ctf.isLocked(c, challengeID) {
+		return errChallengeLocked
 	}
 
 	challenge := ctf.Challenges[challengeID]
 
 	for _, hint := range challenge.Hints {
 		if hint.UID == hintID {
-			err := dbInsertHint(ctf.Storage, sess.Get("user").(int), challengeID, hintID, hint.Cost)
+			if slices.Contains(ctf.getHints(c, challengeID), hintID) {
+				// already bought by a team member
+				return nil
+			}
+			err := dbInsertHint(ctf.Storage, user.id, challengeID, hintID, hint.Cost)
 			if err != nil {
 				return err
 			}
@@ -304,23 +668,23 @@ func (ctf *ctf) buyHint(c *fiber.Ctx, challengeID, hintID string) error {
 		}
 	}
 
-	return fmt.Errorf("hint dows not exist")
+	return errUnknownHint
 }
 
 func (ctf *ctf) getHints(c *fiber.Ctx, challengeID string) []string {
 	var hintIDs []string
 
-	sess, err := ctf.Sessions.Get(c)
+	user, err := ctf.ensureLoggedIn(c)
 	if err != nil {
 		return hintIDs
 	}
 
-	user := sess.Get("user")
-	if user == nil {
+	members, err := user.memberIDs()
+	if err != nil {
 		return hintIDs
 	}
 
-	hintIDs, err = dbHintGetBoughtIDs(ctf.Storage, sess.Get("user").(int), challengeID)
+	hintIDs, err = dbHintGetBoughtIDs(ctf.Storage, members, challengeID)
 	if err != nil {
 		return hintIDs
 	}
@@ -329,25 +693,10 @@ func (ctf *ctf) getHints(c *fiber.Ctx, challengeID string) []string {
 }
 
 func (ctf *ctf) isSolved(c *fiber.Ctx, challengeID string) bool {
-	sess, err := ctf.Sessions.Get(c)
-	if err != nil {
-		return false
-	}
-
-	user := sess.Get("user")
-	if user == nil {
-		return false
-	}
-
 	solved, err := ctf.solvedChallenges(c)
 	if err != nil {
 		return false
 	}
 
-	for _, c := range solved {
-		if c == challengeID {
-			return true
-		}
-	}
-	return false
+	return slices.Contains(solved, challengeID)
 }
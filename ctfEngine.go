@@ -1,8 +1,8 @@
 package main
 
 import (
+	"context"
 	"embed"
-	"flag"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/filesystem"
@@ -12,8 +12,13 @@ import (
 	"log"
 	"math"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 func handleError(c *fiber.Ctx, err error) error {
@@ -21,6 +26,10 @@ func handleError(c *fiber.Ctx, err error) error {
 	return c.Redirect("/")
 }
 
+// shutdownTimeout limits how long the server waits for open requests on
+// shutdown.
+const shutdownTimeout = 10 * time.Second
+
 //go:embed views/*
 var viewsFS embed.FS
 
@@ -28,15 +37,30 @@ var viewsFS embed.FS
 var staticFS embed.FS
 
 func main() {
-	var ctfLocation string
-	var signupTokenToAdd string
-	flag.StringVar(&ctfLocation, "l", "/ctf", "load ctf from this directory")
-	flag.StringVar(&signupTokenToAdd, "a", "", "add a token for signup to the database")
-	flag.Parse()
-	ctf, err := initCTF(ctfLocation)
+	os.Exit(runCommand(os.Args[1:]))
+}
+
+// runServe implements the serve command, which runs the web server.
+func runServe(args []string) int {
+	flags, ctfLocation := commandFlags("serve")
+	serverFlags := addServerFlags(flags)
+	_ = flags.Parse(args)
+	ctf, ok := loadCTF(*ctfLocation)
+	if !ok {
+		return 1
+	}
+
+	settings, err := serverFlags.serverSettings(ctf.Configuration)
 	if err != nil {
-		fmt.Printf("Could not load CTF from path \"%s\".\n", ctfLocation)
-		return
+		fmt.Printf("Invalid server settings: %s.\n", err)
+		return 1
+	}
+	ctf.promoteAdmins()
+	ctf.BasePath = settings.BasePath
+	ctf.TrustedProxies, _ = parseProxies(settings.TrustedProxies)
+	ctf.Sessions.CookiePath = settings.BasePath
+	if ctf.Sessions.CookiePath == "" {
+		ctf.Sessions.CookiePath = "/"
 	}
 
 	engine := html.NewFileSystem(http.FS(viewsFS), ".html")
@@ -62,6 +86,16 @@ func main() {
 			return template.HTML(strconv.FormatFloat(getSize, 'f', -1, 64) + " " + getSuffix)
 		},
 	)
+	engine.AddFunc(
+		"basePath", func() string {
+			return settings.BasePath
+		},
+	)
+	engine.AddFunc(
+		"inc", func(i int) int {
+			return i + 1
+		},
+	)
 	engine.AddFunc(
 		"inList", func(element string, list []string) bool {
 			for _, listElement := range list {
@@ -82,25 +116,65 @@ func main() {
 			return c.Render("views/error", fiber.Map{}, "views/layouts/main")
 
 		},
+		// X-Forwarded-Proto and -Host are only used for requests from trusted
+		// proxies, client IPs are taken from X-Forwarded-For by ctf.clientIP
+		EnableTrustedProxyCheck: len(settings.TrustedProxies) > 0,
+		TrustedProxies:          settings.TrustedProxies,
 	})
 
+	if settings.BasePath != "" {
+		app.Use(basePathMiddleware(settings.BasePath))
+	}
+
 	app.Use("/static", filesystem.New(filesystem.Config{
 		Root:       http.FS(staticFS),
 		PathPrefix: "static",
 		Browse:     true,
 	}))
 
-	if signupTokenToAdd != "" {
-		err = ctf.addSignupToken(signupTokenToAdd)
-		if err == nil {
-			fmt.Println("added signup token to DB")
-		} else {
-			fmt.Println("could not add signup token to DB")
+	addRoutes(app, &ctf)
+
+	// SIGINT and SIGTERM shut the server down, afterwards the supervisors stop
+	// their services before the database is closed
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	supervisors := []func(context.Context){ctf.watch, ctf.watchHealth}
+	if ctf.Services != nil {
+		supervisors = append(supervisors, ctf.superviseServices)
+	}
+	var running sync.WaitGroup
+	for _, supervise := range supervisors {
+		running.Add(1)
+		go func(supervise func(context.Context)) {
+			defer running.Done()
+			supervise(ctx)
+		}(supervise)
+	}
+
+	go func() {
+		<-ctx.Done()
+		// event streams only end when their subscription is closed
+		ctf.Events.close()
+		if err := app.ShutdownWithTimeout(shutdownTimeout); err != nil {
+			fmt.Printf("[ERROR] could not shut down the server: %s!\n", err)
 		}
-		return
+	}()
+
+	if settings.TLSCert != "" {
+		err = app.ListenTLS(settings.Listen, settings.TLSCert, settings.TLSKey)
+	} else {
+		err = app.Listen(settings.Listen)
 	}
 
-	addRoutes(app, &ctf)
+	stop()
+	running.Wait()
+	_ = ctf.Storage.Close()
 
-	log.Fatal(app.Listen(":3000"))
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	fmt.Println("[INFO] shut down")
+	return 0
 }
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestCTF creates a ctf with the given ctf.yml and challenges in a
+// temporary directory. challenges maps challenge ids to their challenge.yml.
+func newTestCTF(t *testing.T, conf string, challenges map[string]string) *ctf {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ctf.yml"), []byte(conf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for id, challenge := range challenges {
+		challengeDir := filepath.Join(dir, "challenges", id)
+		if err := os.MkdirAll(challengeDir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(challengeDir, "challenge.yml"), []byte(challenge), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// challenges with a service need a Dockerfile
+	for id, challenge := range challenges {
+		if strings.Contains(challenge, "service:") {
+			if err := os.WriteFile(filepath.Join(dir, "challenges", id, "Dockerfile"), []byte("FROM scratch\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	ctf, err := initCTF(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = ctf.Storage.Close()
+	})
+	return &ctf
+}
+
+func TestCurrentChallengesFreeze(t *testing.T) {
+	ctf := newTestCTF(t, "title: test\nsessionTimeout: 600\nfreeze: 2000-01-01T00:00:00Z\n", map[string]string{
+		"dynamic": "name: dynamic\nflag: CTF{dynamic}\nscoring:\n  initial: 500\n  minimum: 100\n  decay: 4\n",
+	})
+
+	// both solves are after the freeze
+	for _, name := range []string{"alice", "bob"} {
+		id, err := ctf.createUser(name, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := dbChallengeAddSolve(ctf.Storage, id, "dynamic", 500, 0, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	frozen, err := ctf.currentChallenges(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := frozen["dynamic"].Points; got != 500 {
+		t.Errorf("frozen value is %d, want 500", got)
+	}
+
+	live, err := ctf.currentChallenges(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := live["dynamic"].Points; got >= 500 {
+		t.Errorf("live value is %d, want it to have decayed", got)
+	}
+}
+
+func TestHiddenSolvesDoNotDecay(t *testing.T) {
+	ctf := newTestCTF(t, "title: test\nsessionTimeout: 600\n", map[string]string{
+		"dynamic": "name: dynamic\nflag: CTF{dynamic}\nscoring:\n  initial: 500\n  minimum: 100\n  decay: 2\n",
+	})
+
+	for _, name := range []string{"organizer", "tester"} {
+		id, err := ctf.createUser(name, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := dbUserSetHidden(ctf.Storage, id, true); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := dbChallengeAddSolve(ctf.Storage, id, "dynamic", 500, 0, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	challenges, err := ctf.currentChallenges(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := challenges["dynamic"].Points; got != 500 {
+		t.Errorf("value after hidden solves is %d, want 500", got)
+	}
+}
+
+func TestTeamSolvesOnce(t *testing.T) {
+	ctf := newTestCTF(t, "title: test\nsessionTimeout: 600\n", map[string]string{
+		"static": "name: static\nflag: CTF{static}\n",
+	})
+
+	var ids []int
+	for _, name := range []string{"alice", "bob", "carol"} {
+		id, err := ctf.createUser(name, name+" password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	team, err := dbTeamCreate(ctf.Storage, "team", "invite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids[:2] {
+		if err := dbTeamAddMember(ctf.Storage, team, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// alice and bob submit at the same time, only one solve counts
+	var wg sync.WaitGroup
+	added := make([]bool, 2)
+	for i, id := range ids[:2] {
+		wg.Add(1)
+		go func(i, id int) {
+			defer wg.Done()
+			_, _, err := dbChallengeAddSolve(ctf.Storage, id, "static", 100, 0, nil)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				t.Error(err)
+			}
+			added[i] = err == nil
+		}(i, id)
+	}
+	wg.Wait()
+	if added[0] == added[1] {
+		t.Fatalf("solves of the team added: %v, want exactly one", added)
+	}
+
+	// carol is not in the team and solves on their own, but only once
+	for i, want := range []bool{true, false} {
+		_, _, err := dbChallengeAddSolve(ctf.Storage, ids[2], "static", 100, 0, nil)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			t.Fatal(err)
+		}
+		if got := err == nil; got != want {
+			t.Fatalf("solve %d of carol added = %v, want %v", i+1, got, want)
+		}
+	}
+}
+
+func TestConcurrentFirstBloods(t *testing.T) {
+	ctf := newTestCTF(t, "title: test\nsessionTimeout: 600\nfirstBloodBonus: [50, 25, 10]\n", map[string]string{
+		"static": "name: static\nflag: CTF{static}\n",
+	})
+
+	var ids []int
+	for i := 0; i < 6; i++ {
+		id, err := ctf.createUser(fmt.Sprintf("user%d", i), "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	hidden := ids[0]
+	if err := dbUserSetHidden(ctf.Storage, hidden, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// everybody solves at the same moment
+	bloods := make([]int, len(ids))
+	bonuses := make([]int, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i, id int) {
+			defer wg.Done()
+			var err error
+			bloods[i], bonuses[i], err = dbChallengeAddSolve(ctf.Storage, id, "static", 100, 0, ctf.firstBloodBonus("static"))
+			if err != nil {
+				t.Error(err)
+			}
+		}(i, id)
+	}
+	wg.Wait()
+
+	if bloods[0] != 0 || bonuses[0] != 0 {
+		t.Errorf("hidden user got rank %d and bonus %d", bloods[0], bonuses[0])
+	}
+	ranks := make(map[int]int)
+	for i := 1; i < len(ids); i++ {
+		ranks[bloods[i]]++
+		if want := ctf.Configuration.FirstBloodBonus.points(bloods[i]); bonuses[i] != want {
+			t.Errorf("rank %d got bonus %d, want %d", bloods[i], bonuses[i], want)
+		}
+	}
+	if ranks[1] != 1 || ranks[2] != 1 || ranks[3] != 1 || ranks[0] != 2 {
+		t.Fatalf("ranks of concurrent solves %v, want 1, 2 and 3 once", bloods[1:])
+	}
+}
+
+func TestRankScores(t *testing.T) {
+	early := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	late := early.Add(time.Hour)
+
+	type ranked struct {
+		User     string
+		Position int
+	}
+	for _, test := range []struct {
+		name   string
+		scores []score
+		want   []ranked
+	}{
+		{
+			name: "points",
+			scores: []score{
+				{User: "a", Points: 100, LastSolve: early},
+				{User: "b", Points: 300, LastSolve: late},
+				{User: "c", Points: 200, LastSolve: early},
+			},
+			want: []ranked{{"b", 1}, {"c", 2}, {"a", 3}},
+		},
+		{
+			name: "equal points, earlier last solve first",
+			scores: []score{
+				{User: "a", Points: 100, LastSolve: late},
+				{User: "b", Points: 100, LastSolve: early},
+			},
+			want: []ranked{{"b", 1}, {"a", 2}},
+		},
+		{
+			name: "equal points and last solve share the position",
+			scores: []score{
+				{User: "c", Points: 200, LastSolve: early},
+				{User: "b", Points: 100, LastSolve: early},
+				{User: "a", Points: 100, LastSolve: early},
+				{User: "d", Points: 50, LastSolve: late},
+			},
+			want: []ranked{{"c", 1}, {"a", 2}, {"b", 2}, {"d", 4}},
+		},
+		{
+			name: "without solves last",
+			scores: []score{
+				{User: "z", Points: 0},
+				{User: "a", Points: 0},
+				{User: "m", Points: 0, LastSolve: late},
+				{User: "b", Points: 10, LastSolve: late},
+			},
+			want: []ranked{{"b", 1}, {"m", 2}, {"a", 3}, {"z", 3}},
+		},
+	} {
+		rankScores(test.scores)
+		var got []ranked
+		for _, s := range test.scores {
+			got = append(got, ranked{s.User, s.Position})
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: ranked %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestTeamScoresWithoutSolves(t *testing.T) {
+	ctf := newTestCTF(t, "title: test\nsessionTimeout: 600\nteams: true\n", map[string]string{
+		"static": "name: static\nflag: CTF{static}\nvalue: 100\n",
+	})
+
+	for _, name := range []string{"alpha", "beta", "gamma"} {
+		id, err := ctf.createUser(name+"-player", "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		team, err := dbTeamCreate(ctf.Storage, name, name+"-invite")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := dbTeamAddMember(ctf.Storage, team, id); err != nil {
+			t.Fatal(err)
+		}
+		if name == "gamma" {
+			if _, _, err := dbChallengeAddSolve(ctf.Storage, id, "static", 100, 0, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	scores, err := ctf.scores(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range scores {
+		got = append(got, fmt.Sprintf("%d %s %d", s.Position, s.User, s.Points))
+	}
+	want := []string{"1 gamma 100", "2 alpha 0", "2 beta 0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("scores %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"sort"
+	"time"
+)
+
+// ctftimeFeed is the scoreboard feed format of CTFtime, see
+// https://ctftime.org/json-scoreboard-feed
+type ctftimeFeed struct {
+	Tasks     []string          `json:"tasks"`
+	Standings []ctftimeStanding `json:"standings"`
+}
+
+type ctftimeStanding struct {
+	Position   int                        `json:"pos"`
+	Team       string                     `json:"team"`
+	Score      int                        `json:"score"`
+	TaskStats  map[string]ctftimeTaskStat `json:"taskStats,omitempty"`
+	LastAccept int64                      `json:"lastAccept,omitempty"`
+}
+
+type ctftimeTaskStat struct {
+	Points int   `json:"points"`
+	Time   int64 `json:"time"`
+}
+
+// ctftimeFeed returns the public scoreboard in the CTFtime format. Tasks are
+// named by their challenge title.
+func (ctf *ctf) ctftimeFeed() (ctftimeFeed, error) {
+	feed := ctftimeFeed{Tasks: []string{}, Standings: []ctftimeStanding{}}
+
+	scores, err := ctf.scores(false)
+	if err != nil {
+		return feed, err
+	}
+
+	until := ctf.scoreboardUntil(false)
+	values, err := ctf.values(until)
+	if err != nil {
+		return feed, err
+	}
+	solves, err := dbGetSolves(ctf.Storage, values, until, ctf.Configuration.Teams)
+	if err != nil {
+		return feed, err
+	}
+
+	ids := make([]string, 0, len(ctf.Challenges))
+	for id := range ctf.Challenges {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		feed.Tasks = append(feed.Tasks, ctf.Challenges[id].Title)
+	}
+
+	stats := make(map[string]map[string]ctftimeTaskStat)
+	for _, row := range solves {
+		player := row[0].(string)
+		cha, ok := ctf.Challenges[row[1].(string)]
+		if !ok {
+			continue
+		}
+		if stats[player] == nil {
+			stats[player] = make(map[string]ctftimeTaskStat)
+		}
+		// solves are ordered by time, so the first solve of a team is kept
+		if _, ok := stats[player][cha.Title]; !ok {
+			stats[player][cha.Title] = ctftimeTaskStat{Points: row[2].(int), Time: row[3].(time.Time).Unix()}
+		}
+	}
+
+	for _, s := range scores {
+		standing := ctftimeStanding{
+			Position:  s.Position,
+			Team:      s.User,
+			Score:     s.Points,
+			TaskStats: stats[s.Player],
+		}
+		if !s.LastSolve.IsZero() {
+			standing.LastAccept = s.LastSolve.Unix()
+		}
+		feed.Standings = append(feed.Standings, standing)
+	}
+	return feed, nil
+}
+
+func rGetCTFtime(c *fiber.Ctx, ctf *ctf) error {
+	feed, err := ctf.ctftimeFeed()
+	if err != nil {
+		return handleError(c, err)
+	}
+	return c.JSON(feed)
+}
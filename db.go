@@ -2,7 +2,9 @@ package main
 
 import (
 	"database/sql"
+	"fmt"
 	"github.com/gofiber/storage/sqlite3"
+	"strings"
 	"time"
 )
 
@@ -46,13 +48,100 @@ func dbInitStorage(db *sql.DB) error {
 		);
 		CREATE TABLE IF NOT EXISTS signuptokens (
 			token STRING NOT NULL PRIMARY KEY
+		);
+		CREATE TABLE IF NOT EXISTS teams (
+			id INTEGER NOT NULL PRIMARY KEY,
+			name TEXT UNIQUE NOT NULL,
+			invite TEXT UNIQUE NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS teammembers (
+			user INTEGER NOT NULL PRIMARY KEY,
+			team INTEGER NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS submissions (
+			id INTEGER NOT NULL PRIMARY KEY,
+			user INTEGER NOT NULL,
+			Challenge TEXT NOT NULL,
+			flag TEXT NOT NULL,
+			correct BOOLEAN NOT NULL,
+			ip TEXT NOT NULL,
+			time DATETIME NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS sharing (
+			id INTEGER NOT NULL PRIMARY KEY,
+			user INTEGER NOT NULL,
+			Challenge TEXT NOT NULL,
+			owner TEXT NOT NULL,
+			flag TEXT NOT NULL,
+			time DATETIME NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS apitokens (
+			id INTEGER NOT NULL PRIMARY KEY,
+			user INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			hash TEXT UNIQUE NOT NULL,
+			created DATETIME NOT NULL,
+			lastused DATETIME
+		);
+		CREATE TABLE IF NOT EXISTS announcements (
+			id INTEGER NOT NULL PRIMARY KEY,
+			title TEXT NOT NULL,
+			text TEXT NOT NULL,
+			challenge TEXT NOT NULL,
+			priority INTEGER NOT NULL,
+			time DATETIME NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS instances (
+			player TEXT NOT NULL,
+			challenge TEXT NOT NULL,
+			port INTEGER UNIQUE NOT NULL,
+			expires DATETIME NOT NULL,
+			PRIMARY KEY (player, challenge)
+		);
+		CREATE TABLE IF NOT EXISTS settings (
+			key TEXT NOT NULL PRIMARY KEY,
+			value TEXT NOT NULL
 		);`
 	if _, err := db.Exec(initDB); err != nil {
 		return err
 	}
+
+	// columns added after the initial release
+	if err := dbAddColumn(db, "score", "hintcost", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := dbAddColumn(db, "users", "admin", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := dbAddColumn(db, "users", "hidden", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := dbAddColumn(db, "users", "announcement", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := dbAddColumn(db, "score", "blood", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := dbAddColumn(db, "score", "bonus", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
 	return nil
 }
 
+// dbAddColumn adds a column to an existing table unless it already exists.
+func dbAddColumn(db *sql.DB, table, column, definition string) error {
+	var count int
+	row := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info($1) WHERE name=$2;`, table, column)
+	if err := row.Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	_, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", table, column, definition))
+	return err
+}
+
 // Users
 
 func dbUserGetName(db *sql.DB, id int) (string, error) {
@@ -65,9 +154,13 @@ func dbUserGetName(db *sql.DB, id int) (string, error) {
 	return name, nil
 }
 
-func dbUserGetScore(db *sql.DB, id int) (int, error) {
+func dbUserGetScore(db *sql.DB, userIDs []int, values map[string]int) (int, error) {
 	var score int
-	row := db.QueryRow(`SELECT COALESCE(SUM(score.points), 0) FROM score WHERE user=$1;`, id)
+	with, args := dbValues(values)
+	in, inArgs := dbInList(userIDs)
+	row := db.QueryRow(with+`SELECT COALESCE(SUM(`+dbScorePoints+`), 0) FROM score
+										LEFT JOIN dynamic ON dynamic.challenge = score.Challenge
+										WHERE score.user IN `+in+`;`, append(args, inArgs...)...)
 	err := row.Scan(&score)
 	if err != nil {
 		return 0, err
@@ -75,8 +168,10 @@ func dbUserGetScore(db *sql.DB, id int) (int, error) {
 	return score, nil
 }
 
-func dbUserRegister(db *sql.DB, username, hash, salt string) (int, error) {
-	res, err := db.Exec("INSERT INTO users VALUES(NULL,?,?,?);", username, hash[:], salt)
+// dbUserRegister stores a new user. The salt column is only used by legacy
+// sha256 hashes, PHC formatted hashes carry their own salt.
+func dbUserRegister(db *sql.DB, username, hash string) (int, error) {
+	res, err := db.Exec("INSERT INTO users (name, password, salt) VALUES(?,?,?);", username, hash, "")
 	if err != nil {
 		return 0, err
 	}
@@ -87,17 +182,269 @@ func dbUserRegister(db *sql.DB, username, hash, salt string) (int, error) {
 	return int(id), err
 }
 
-// Challenges
+func dbUserGetPassword(db *sql.DB, username string) (int, string, string, error) {
+	var id int
+	var hash string
+	var salt string
+	row := db.QueryRow(`SELECT id, password, salt FROM users WHERE name=$1;`, username)
+	err := row.Scan(&id, &hash, &salt)
+	if err != nil {
+		return 0, "", "", err
+	}
+	return id, hash, salt, nil
+}
+
+func dbUserSetPassword(db *sql.DB, id int, hash string) error {
+	_, err := db.Exec("UPDATE users SET password=?, salt=? WHERE id=?;", hash, "", id)
+	return err
+}
+
+func dbUserGetID(db *sql.DB, username string) (int, error) {
+	var id int
+	row := db.QueryRow(`SELECT id FROM users WHERE name=$1;`, username)
+	err := row.Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func dbUserIsAdmin(db *sql.DB, id int) (bool, error) {
+	var admin bool
+	row := db.QueryRow(`SELECT admin FROM users WHERE id=$1;`, id)
+	err := row.Scan(&admin)
+	if err != nil {
+		return false, err
+	}
+	return admin, nil
+}
+
+func dbUserIsHidden(db *sql.DB, id int) (bool, error) {
+	var hidden bool
+	row := db.QueryRow(`SELECT hidden FROM users WHERE id=$1;`, id)
+	err := row.Scan(&hidden)
+	if err != nil {
+		return false, err
+	}
+	return hidden, nil
+}
+
+func dbUserSetAdmin(db *sql.DB, id int, admin bool) error {
+	_, err := db.Exec("UPDATE users SET admin=? WHERE id=?;", admin, id)
+	return err
+}
 
-func dbChallengeAddSolve(db *sql.DB, userID int, challengeID string, challengePoints int) error {
-	_, err := db.Exec("INSERT INTO score VALUES(NULL,?,?,?, ?);", userID, challengeID, challengePoints, time.Now().UTC())
+func dbUserSetHidden(db *sql.DB, id int, hidden bool) error {
+	_, err := db.Exec("UPDATE users SET hidden=? WHERE id=?;", hidden, id)
 	return err
 }
 
-func dbChallengeGetSolved(db *sql.DB, userID int) ([]string, error) {
+// dbUserDelete removes a user with its solves, hints, submissions and API
+// tokens.
+func dbUserDelete(db *sql.DB, id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, query := range []string{
+		"DELETE FROM score WHERE user=?;",
+		"DELETE FROM hints WHERE user=?;",
+		"DELETE FROM submissions WHERE user=?;",
+		"DELETE FROM sharing WHERE user=?;",
+		"DELETE FROM apitokens WHERE user=?;",
+		"DELETE FROM teammembers WHERE user=?;",
+		"DELETE FROM users WHERE id=?;",
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func dbGetUsers(db *sql.DB) ([][]interface{}, error) {
+	var users [][]interface{}
+
+	rows, err := db.Query(`SELECT users.id, users.name, COALESCE(teams.name, ''), users.admin, users.hidden
+										FROM users
+										LEFT JOIN teammembers ON users.id = teammembers.user
+										LEFT JOIN teams ON teams.id = teammembers.team
+										ORDER BY users.name;`)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	for rows.Next() {
+		var id int
+		var name string
+		var team string
+		var admin bool
+		var hidden bool
+		if err := rows.Scan(&id, &name, &team, &admin, &hidden); err != nil {
+			return users, err
+		}
+		users = append(users, []interface{}{id, name, team, admin, hidden})
+	}
+	if err = rows.Err(); err != nil {
+		return users, err
+	}
+	return users, nil
+}
+
+// Challenges
+
+// dbChallengeAddSolve records a solve unless the user or a member of their
+// team already solved the challenge, then sql.ErrNoRows is returned. The
+// check is part of the insert, so concurrent submissions of a team cannot both
+// be recorded. The insert also ranks the solve among the first solves of the
+// challenge by users that are not hidden, so concurrent solves of a challenge
+// get distinct ranks. It returns the rank, zero if it is none of the first
+// solves, and its points of bonus.
+func dbChallengeAddSolve(db *sql.DB, userID int, challengeID string, challengePoints, hintCost int, bonus firstBloodBonus) (int, int, error) {
+	// SQLite numbers $ parameters in the order of their first use
+	args := []interface{}{userID, challengeID, challengePoints, time.Now().UTC(), hintCost}
+	bonusCase := "CASE ranked.blood"
+	for rank := 1; rank <= firstBloodRanks; rank++ {
+		args = append(args, bonus.points(rank))
+		bonusCase += fmt.Sprintf(" WHEN %d THEN $%d", rank, len(args))
+	}
+	bonusCase += " ELSE 0 END"
+	args = append(args, firstBloodRanks)
+	ranks := fmt.Sprintf("$%d", len(args))
+
+	var blood, points int
+	row := db.QueryRow(`INSERT INTO score (user, Challenge, points, time, hintcost, blood, bonus)
+										SELECT $1, $2, $3, $4, $5, ranked.blood, `+bonusCase+`
+										FROM (SELECT CASE
+											WHEN (SELECT hidden FROM users WHERE id = $1) THEN 0
+											WHEN COUNT(*) < `+ranks+` THEN COUNT(*) + 1
+											ELSE 0 END AS blood
+											FROM score JOIN users ON users.id = score.user
+											WHERE score.Challenge = $2 AND users.hidden = 0) AS ranked
+										WHERE NOT EXISTS (SELECT 1 FROM score WHERE score.Challenge = $2 AND score.user IN (
+											SELECT $1 UNION
+											SELECT teammembers.user FROM teammembers
+											WHERE teammembers.team = (SELECT team FROM teammembers WHERE user = $1)))
+										RETURNING blood, bonus;`, args...)
+	if err := row.Scan(&blood, &points); err != nil {
+		return 0, 0, err
+	}
+	return blood, points, nil
+}
+
+// dbGetFirstBloods returns the challenge, rank and player name of the first
+// solves before until. Players are named by their team if teams is set.
+func dbGetFirstBloods(db *sql.DB, until time.Time, teams bool) ([][]interface{}, error) {
+	var bloods [][]interface{}
+
+	name := `users.name`
+	if teams {
+		name = `COALESCE(teams.name, users.name)`
+	}
+
+	rows, err := db.Query(`SELECT score.Challenge, score.blood, `+name+` FROM score
+										JOIN users ON users.id = score.user
+										LEFT JOIN teammembers ON users.id = teammembers.user
+										LEFT JOIN teams ON teams.id = teammembers.team
+										WHERE score.blood > 0 AND users.hidden = 0 AND score.time < ?
+										ORDER BY score.Challenge, score.blood;`, dbUntil(until))
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	for rows.Next() {
+		var challenge string
+		var blood int
+		var player string
+		if err := rows.Scan(&challenge, &blood, &player); err != nil {
+			return bloods, err
+		}
+		bloods = append(bloods, []interface{}{challenge, blood, player})
+	}
+	if err = rows.Err(); err != nil {
+		return bloods, err
+	}
+	return bloods, nil
+}
+
+// dbChallengeGetVisibleSolveCounts counts the solves before until per
+// challenge, leaving out hidden users.
+func dbChallengeGetVisibleSolveCounts(db *sql.DB, until time.Time) (map[string]int, error) {
+	counts := make(map[string]int)
+
+	rows, err := db.Query(`SELECT score.Challenge, COUNT(*) FROM score JOIN users ON users.id = score.user
+										WHERE users.hidden = 0 AND score.time < $1 GROUP BY score.Challenge;`, dbUntil(until))
+	if err != nil {
+		return counts, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	for rows.Next() {
+		var challenge string
+		var count int
+		if err := rows.Scan(&challenge, &count); err != nil {
+			return counts, err
+		}
+		counts[challenge] = count
+	}
+	if err = rows.Err(); err != nil {
+		return counts, err
+	}
+	return counts, nil
+}
+
+// dbChallengeGetSolvers returns the name and time of all solves of a
+// challenge before until, leaving out hidden users. Players are named by
+// their team if teams is set.
+func dbChallengeGetSolvers(db *sql.DB, challengeID string, until time.Time, teams bool) ([][]interface{}, error) {
+	var solvers [][]interface{}
+
+	name := `users.name`
+	if teams {
+		name = `COALESCE(teams.name, users.name)`
+	}
+
+	rows, err := db.Query(`SELECT `+name+`, score.time FROM score
+										JOIN users ON users.id = score.user
+										LEFT JOIN teammembers ON users.id = teammembers.user
+										LEFT JOIN teams ON teams.id = teammembers.team
+										WHERE score.Challenge = ? AND users.hidden = 0 AND score.time < ?
+										ORDER BY score.time;`, challengeID, dbUntil(until))
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	for rows.Next() {
+		var player string
+		var solved time.Time
+		if err := rows.Scan(&player, &solved); err != nil {
+			return solvers, err
+		}
+		solvers = append(solvers, []interface{}{player, solved})
+	}
+	if err = rows.Err(); err != nil {
+		return solvers, err
+	}
+	return solvers, nil
+}
+
+func dbChallengeGetSolved(db *sql.DB, userIDs []int) ([]string, error) {
 	var Challenges []string
 
-	rows, err := db.Query(`SELECT Challenge FROM score WHERE user=$1;`, userID)
+	in, args := dbInList(userIDs)
+	rows, err := db.Query(`SELECT DISTINCT Challenge FROM score WHERE user IN `+in+`;`, args...)
 	if err != nil {
 		return Challenges, err
 	}
@@ -118,16 +465,241 @@ func dbChallengeGetSolved(db *sql.DB, userID int) ([]string, error) {
 	return Challenges, nil
 }
 
+// Submissions
+
+func dbInsertSubmission(db *sql.DB, userID int, challengeID, flag string, correct bool, ip string) error {
+	_, err := db.Exec("INSERT INTO submissions VALUES(NULL,?,?,?,?,?,?);",
+		userID, challengeID, flag, correct, ip, time.Now().UTC())
+	return err
+}
+
+func dbSubmissionCountWrong(db *sql.DB, userID int, since time.Time) (int, error) {
+	var count int
+	row := db.QueryRow(`SELECT COUNT(*) FROM submissions WHERE user=$1 AND NOT correct AND time >= $2;`,
+		userID, since.UTC())
+	err := row.Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func dbGetSubmissions(db *sql.DB) ([][]interface{}, error) {
+	var submissions [][]interface{}
+
+	rows, err := db.Query(`SELECT COALESCE(users.name, ''), submissions.Challenge, submissions.flag,
+										submissions.correct, submissions.ip, submissions.time
+										FROM submissions
+										LEFT JOIN users ON users.id = submissions.user
+										ORDER BY submissions.time DESC;`)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	for rows.Next() {
+		var name string
+		var challenge string
+		var flag string
+		var correct bool
+		var ip string
+		var submitted time.Time
+		if err := rows.Scan(&name, &challenge, &flag, &correct, &ip, &submitted); err != nil {
+			return submissions, err
+		}
+		submissions = append(submissions, []interface{}{name, challenge, flag, correct, ip, submitted})
+	}
+	if err = rows.Err(); err != nil {
+		return submissions, err
+	}
+	return submissions, nil
+}
+
+func dbInsertSharing(db *sql.DB, userID int, challengeID, owner, flag string) error {
+	_, err := db.Exec("INSERT INTO sharing VALUES(NULL,?,?,?,?,?);",
+		userID, challengeID, owner, flag, time.Now().UTC())
+	return err
+}
+
+func dbGetSharing(db *sql.DB) ([][]interface{}, error) {
+	var sharing [][]interface{}
+
+	rows, err := db.Query(`SELECT COALESCE(users.name, ''), sharing.Challenge, sharing.owner, sharing.flag, sharing.time
+										FROM sharing
+										LEFT JOIN users ON users.id = sharing.user
+										ORDER BY sharing.time DESC;`)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	for rows.Next() {
+		var name string
+		var challenge string
+		var owner string
+		var flag string
+		var submitted time.Time
+		if err := rows.Scan(&name, &challenge, &owner, &flag, &submitted); err != nil {
+			return sharing, err
+		}
+		sharing = append(sharing, []interface{}{name, challenge, owner, flag, submitted})
+	}
+	if err = rows.Err(); err != nil {
+		return sharing, err
+	}
+	return sharing, nil
+}
+
+// Teams
+
+func dbTeamCreate(db *sql.DB, name, invite string) (int, error) {
+	res, err := db.Exec("INSERT INTO teams VALUES(NULL,?,?);", name, invite)
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	if id, err = res.LastInsertId(); err != nil {
+		return 0, err
+	}
+	return int(id), err
+}
+
+func dbTeamGetByInvite(db *sql.DB, invite string) (int, error) {
+	var id int
+	row := db.QueryRow(`SELECT id FROM teams WHERE invite=$1;`, invite)
+	err := row.Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func dbTeamGet(db *sql.DB, teamID int) (string, string, error) {
+	var name string
+	var invite string
+	row := db.QueryRow(`SELECT name, invite FROM teams WHERE id=$1;`, teamID)
+	err := row.Scan(&name, &invite)
+	if err != nil {
+		return "", "", err
+	}
+	return name, invite, nil
+}
+
+func dbTeamDelete(db *sql.DB, teamID int) error {
+	_, err := db.Exec("DELETE FROM teams WHERE id=?;", teamID)
+	return err
+}
+
+func dbUserGetTeam(db *sql.DB, userID int) (int, error) {
+	var team int
+	row := db.QueryRow(`SELECT team FROM teammembers WHERE user=$1;`, userID)
+	err := row.Scan(&team)
+	if err != nil {
+		return 0, err
+	}
+	return team, nil
+}
+
+func dbTeamAddMember(db *sql.DB, teamID, userID int) error {
+	_, err := db.Exec("INSERT INTO teammembers VALUES(?,?);", userID, teamID)
+	return err
+}
+
+func dbTeamRemoveMember(db *sql.DB, userID int) error {
+	_, err := db.Exec("DELETE FROM teammembers WHERE user=?;", userID)
+	return err
+}
+
+func dbTeamGetMembers(db *sql.DB, teamID int) ([]int, []string, error) {
+	var ids []int
+	var names []string
+
+	rows, err := db.Query(`SELECT users.id, users.name FROM teammembers
+										JOIN users ON users.id = teammembers.user
+										WHERE teammembers.team=$1
+										ORDER BY users.name;`, teamID)
+	if err != nil {
+		return ids, names, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return ids, names, err
+		}
+		ids = append(ids, id)
+		names = append(names, name)
+	}
+	if err = rows.Err(); err != nil {
+		return ids, names, err
+	}
+	return ids, names, nil
+}
+
+func dbUserHasActivity(db *sql.DB, userID int) (bool, error) {
+	var count int
+	row := db.QueryRow(`SELECT (SELECT COUNT(*) FROM score WHERE user=$1) +
+										(SELECT COUNT(*) FROM hints WHERE user=$1);`, userID)
+	err := row.Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// dbGetPlayers returns the key and name of everyone competing, i.e. every
+// team and every user without a team. Keys are "t<team id>" for teams and
+// "u<user id>" for users.
+func dbGetPlayers(db *sql.DB) ([][]interface{}, error) {
+	var players [][]interface{}
+
+	rows, err := db.Query(`SELECT DISTINCT CASE WHEN teams.id IS NULL THEN 'u' || users.id ELSE 't' || teams.id END,
+										COALESCE(teams.name, users.name)
+										FROM users
+										LEFT JOIN teammembers ON users.id = teammembers.user
+										LEFT JOIN teams ON teams.id = teammembers.team;`)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	for rows.Next() {
+		var key string
+		var name string
+		if err := rows.Scan(&key, &name); err != nil {
+			return players, err
+		}
+		players = append(players, []interface{}{key, name})
+	}
+	if err = rows.Err(); err != nil {
+		return players, err
+	}
+	return players, nil
+}
+
 // Score
 
-func dbGetScoreboard(db *sql.DB) ([][]interface{}, error) {
+func dbGetScoreboard(db *sql.DB, values map[string]int, until time.Time) ([][]interface{}, error) {
 	var scores [][]interface{}
 
-	rows, err := db.Query(`SELECT users.id, users.name, COALESCE(SUM(score.points), 0) AS total_points
+	with, args := dbValues(values)
+	rows, err := db.Query(with+`SELECT 'u' || users.id AS player, users.name, COALESCE(SUM(`+dbScorePoints+`), 0) AS total_points
 										FROM users
-										LEFT JOIN score ON users.id = score.user
+										LEFT JOIN score ON users.id = score.user AND score.time < ?
+										LEFT JOIN dynamic ON dynamic.challenge = score.Challenge
+										WHERE users.hidden = 0
 										GROUP BY users.id, users.name
-										ORDER BY total_points DESC;`)
+										ORDER BY total_points DESC;`, append(args, dbUntil(until))...)
 	if err != nil {
 		return nil, err
 	}
@@ -136,7 +708,7 @@ func dbGetScoreboard(db *sql.DB) ([][]interface{}, error) {
 	}(rows)
 
 	for rows.Next() {
-		var id int
+		var id string
 		var name string
 		var score int
 		if err := rows.Scan(&id, &name, &score); err != nil {
@@ -150,6 +722,88 @@ func dbGetScoreboard(db *sql.DB) ([][]interface{}, error) {
 	return scores, nil
 }
 
+// dbGetTeamScoreboard ranks teams by the summed points of their members.
+// Users without a team are listed on their own.
+func dbGetTeamScoreboard(db *sql.DB, values map[string]int, until time.Time) ([][]interface{}, error) {
+	var scores [][]interface{}
+
+	with, args := dbValues(values)
+	rows, err := db.Query(with+`SELECT CASE WHEN teams.id IS NULL THEN 'u' || users.id ELSE 't' || teams.id END AS player,
+										COALESCE(teams.name, users.name),
+										COALESCE(SUM(`+dbScorePoints+`), 0) AS total_points
+										FROM users
+										LEFT JOIN teammembers ON users.id = teammembers.user
+										LEFT JOIN teams ON teams.id = teammembers.team
+										LEFT JOIN score ON users.id = score.user AND score.time < ?
+										LEFT JOIN dynamic ON dynamic.challenge = score.Challenge
+										WHERE users.hidden = 0
+										GROUP BY player
+										ORDER BY total_points DESC;`, append(args, dbUntil(until))...)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	for rows.Next() {
+		var id string
+		var name string
+		var score int
+		if err := rows.Scan(&id, &name, &score); err != nil {
+			return scores, err
+		}
+		scores = append(scores, []interface{}{id, name, score})
+	}
+	if err = rows.Err(); err != nil {
+		return scores, err
+	}
+	return scores, nil
+}
+
+// dbGetSolves returns the solves of all visible players before until with
+// the points they are currently worth and their first blood rank. Players are identified like
+// user.playerKey, by their team if teams is set.
+func dbGetSolves(db *sql.DB, values map[string]int, until time.Time, teams bool) ([][]interface{}, error) {
+	var solves [][]interface{}
+
+	player := `'u' || users.id`
+	if teams {
+		player = `CASE WHEN teammembers.team IS NULL THEN 'u' || users.id ELSE 't' || teammembers.team END`
+	}
+
+	with, args := dbValues(values)
+	rows, err := db.Query(with+`SELECT `+player+` AS player, score.Challenge, `+dbScorePoints+`, score.time, score.blood
+										FROM score
+										JOIN users ON users.id = score.user
+										LEFT JOIN teammembers ON users.id = teammembers.user
+										LEFT JOIN dynamic ON dynamic.challenge = score.Challenge
+										WHERE users.hidden = 0 AND score.time < ?
+										ORDER BY score.time;`, append(args, dbUntil(until))...)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	for rows.Next() {
+		var player string
+		var challenge string
+		var points int
+		var solved time.Time
+		var blood int
+		if err := rows.Scan(&player, &challenge, &points, &solved, &blood); err != nil {
+			return solves, err
+		}
+		solves = append(solves, []interface{}{player, challenge, points, solved, blood})
+	}
+	if err = rows.Err(); err != nil {
+		return solves, err
+	}
+	return solves, nil
+}
+
 // Hints
 
 func dbInsertHint(db *sql.DB, userID int, challengeID, hintID string, cost int) error {
@@ -160,10 +814,12 @@ func dbInsertHint(db *sql.DB, userID int, challengeID, hintID string, cost int)
 	return nil
 }
 
-func dbHintGetBoughtIDs(db *sql.DB, userID int, challengeID string) ([]string, error) {
+func dbHintGetBoughtIDs(db *sql.DB, userIDs []int, challengeID string) ([]string, error) {
 	var hintIDs []string
 
-	rows, err := db.Query(`SELECT hintid FROM hints WHERE user=$1 AND challenge=$2;`, userID, challengeID)
+	in, args := dbInList(userIDs)
+	rows, err := db.Query(`SELECT DISTINCT hintid FROM hints WHERE challenge=? AND user IN `+in+`;`,
+		append([]interface{}{challengeID}, args...)...)
 	if err != nil {
 		return hintIDs, err
 	}
@@ -184,10 +840,12 @@ func dbHintGetBoughtIDs(db *sql.DB, userID int, challengeID string) ([]string, e
 	return hintIDs, nil
 }
 
-func dbHintGetCost(db *sql.DB, userID int, challengeID string) (int, error) {
+func dbHintGetCost(db *sql.DB, userIDs []int, challengeID string) (int, error) {
 	var costs int
+	in, args := dbInList(userIDs)
 	row := db.QueryRow(
-		`SELECT COALESCE(SUM(hints.points), 0) FROM hints  WHERE user=$1 AND challenge=$2;`, userID, challengeID)
+		`SELECT COALESCE(SUM(hints.points), 0) FROM hints WHERE challenge=? AND user IN `+in+`;`,
+		append([]interface{}{challengeID}, args...)...)
 	//goland:noinspection GoDirectComparisonOfErrors
 	switch err := row.Scan(&costs); err {
 	case sql.ErrNoRows:
@@ -199,6 +857,190 @@ func dbHintGetCost(db *sql.DB, userID int, challengeID string) (int, error) {
 	}
 }
 
+// Announcements
+
+func dbInsertAnnouncement(db *sql.DB, title, text, challengeID string, priority int) (int, error) {
+	res, err := db.Exec("INSERT INTO announcements VALUES(NULL,?,?,?,?,?);",
+		title, text, challengeID, priority, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+func dbDeleteAnnouncement(db *sql.DB, id int) error {
+	_, err := db.Exec("DELETE FROM announcements WHERE id=?;", id)
+	return err
+}
+
+// dbGetAnnouncements returns all announcements after the one with the given
+// ID, the newest first.
+func dbGetAnnouncements(db *sql.DB, after int) ([][]interface{}, error) {
+	var announcements [][]interface{}
+
+	rows, err := db.Query(`SELECT id, title, text, challenge, priority, time FROM announcements
+										WHERE id > $1 ORDER BY id DESC;`, after)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	for rows.Next() {
+		var id int
+		var title string
+		var text string
+		var challenge string
+		var priority int
+		var published time.Time
+		if err := rows.Scan(&id, &title, &text, &challenge, &priority, &published); err != nil {
+			return announcements, err
+		}
+		announcements = append(announcements, []interface{}{id, title, text, challenge, priority, published})
+	}
+	if err = rows.Err(); err != nil {
+		return announcements, err
+	}
+	return announcements, nil
+}
+
+func dbGetLatestAnnouncementID(db *sql.DB) (int, error) {
+	var id int
+	row := db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM announcements;`)
+	err := row.Scan(&id)
+	return id, err
+}
+
+// dbUserGetAnnouncement returns the ID of the last announcement shown to the
+// user.
+func dbUserGetAnnouncement(db *sql.DB, id int) (int, error) {
+	var announcement int
+	row := db.QueryRow(`SELECT announcement FROM users WHERE id=$1;`, id)
+	err := row.Scan(&announcement)
+	return announcement, err
+}
+
+func dbUserSetAnnouncement(db *sql.DB, id, announcement int) error {
+	_, err := db.Exec("UPDATE users SET announcement=? WHERE id=?;", announcement, id)
+	return err
+}
+
+// Instances
+
+func dbInsertInstance(db *sql.DB, player, challengeID string, port int, expires time.Time) error {
+	_, err := db.Exec("INSERT INTO instances VALUES(?,?,?,?);", player, challengeID, port, expires.UTC())
+	return err
+}
+
+func dbSetInstanceExpires(db *sql.DB, player, challengeID string, expires time.Time) error {
+	_, err := db.Exec("UPDATE instances SET expires=? WHERE player=? AND challenge=?;",
+		expires.UTC(), player, challengeID)
+	return err
+}
+
+func dbDeleteInstance(db *sql.DB, player, challengeID string) error {
+	_, err := db.Exec("DELETE FROM instances WHERE player=? AND challenge=?;", player, challengeID)
+	return err
+}
+
+func dbGetInstances(db *sql.DB) ([][]interface{}, error) {
+	var instances [][]interface{}
+
+	rows, err := db.Query(`SELECT player, challenge, port, expires FROM instances ORDER BY expires;`)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	for rows.Next() {
+		var player string
+		var challenge string
+		var port int
+		var expires time.Time
+		if err := rows.Scan(&player, &challenge, &port, &expires); err != nil {
+			return instances, err
+		}
+		instances = append(instances, []interface{}{player, challenge, port, expires})
+	}
+	if err = rows.Err(); err != nil {
+		return instances, err
+	}
+	return instances, nil
+}
+
+// API tokens
+
+func dbInsertAPIToken(db *sql.DB, userID int, name, hash string) error {
+	_, err := db.Exec("INSERT INTO apitokens VALUES(NULL,?,?,?,?,NULL);", userID, name, hash, time.Now().UTC())
+	return err
+}
+
+// dbAPITokenGetUser returns the user of a token hash and updates the time
+// the token was last used.
+func dbAPITokenGetUser(db *sql.DB, hash string) (int, error) {
+	var userID int
+	row := db.QueryRow(`SELECT user FROM apitokens WHERE hash=$1;`, hash)
+	err := row.Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+	_, err = db.Exec("UPDATE apitokens SET lastused=? WHERE hash=?;", time.Now().UTC(), hash)
+	return userID, err
+}
+
+func dbGetAPITokens(db *sql.DB, userID int) ([][]interface{}, error) {
+	var tokens [][]interface{}
+
+	rows, err := db.Query(`SELECT id, name, created, lastused FROM apitokens WHERE user=$1 ORDER BY created;`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	for rows.Next() {
+		var id int
+		var name string
+		var created time.Time
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&id, &name, &created, &lastUsed); err != nil {
+			return tokens, err
+		}
+		tokens = append(tokens, []interface{}{id, name, created, lastUsed.Time})
+	}
+	if err = rows.Err(); err != nil {
+		return tokens, err
+	}
+	return tokens, nil
+}
+
+func dbDeleteAPIToken(db *sql.DB, userID, tokenID int) error {
+	_, err := db.Exec("DELETE FROM apitokens WHERE user=? AND id=?;", userID, tokenID)
+	return err
+}
+
+// Settings
+
+func dbGetSetting(db *sql.DB, key string) (string, error) {
+	var value string
+	row := db.QueryRow(`SELECT value FROM settings WHERE key=$1;`, key)
+	err := row.Scan(&value)
+	if err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+func dbSetSetting(db *sql.DB, key, value string) error {
+	_, err := db.Exec("INSERT OR REPLACE INTO settings VALUES(?,?);", key, value)
+	return err
+}
+
 // SignUpToken
 
 func dbInsertSignupToken(db *sql.DB, token string) error {
@@ -223,11 +1065,237 @@ func dbHasSignupToken(db *sql.DB, token string) (bool, error) {
 }
 
 func dbDeleteSignupToken(db *sql.DB, token string) error {
-	var scanToken string
-	row := db.QueryRow(`DELETE FROM signuptokens WHERE token=$1;`, token)
-	err := row.Scan(&scanToken)
+	_, err := db.Exec(`DELETE FROM signuptokens WHERE token=$1;`, token)
+	return err
+}
+
+func dbGetSignupTokens(db *sql.DB) ([]string, error) {
+	var tokens []string
+
+	rows, err := db.Query(`SELECT token FROM signuptokens ORDER BY token;`)
+	if err != nil {
+		return tokens, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	for rows.Next() {
+		var token string
+		if err := rows.Scan(&token); err != nil {
+			return tokens, err
+		}
+		tokens = append(tokens, token)
+	}
+	if err = rows.Err(); err != nil {
+		return tokens, err
+	}
+	return tokens, nil
+}
+
+// Export
+
+// dbExportTimeFormat is the format in which go-sqlite3 stores times, so
+// imported times compare like the original ones.
+const dbExportTimeFormat = "2006-01-02 15:04:05.999999999-07:00"
+
+// dbGetTables returns the names of all tables except the sessions.
+func dbGetTables(db *sql.DB) ([]string, error) {
+	var tables []string
+
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type='table' AND name!='sessions' AND name NOT LIKE 'sqlite_%' ORDER BY name;`)
+	if err != nil {
+		return tables, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return tables, err
+		}
+		tables = append(tables, table)
+	}
+	if err = rows.Err(); err != nil {
+		return tables, err
+	}
+	return tables, nil
+}
+
+// dbGetTableRows returns all rows of a table as column to value maps.
+func dbGetTableRows(db *sql.DB, table string) ([]map[string]interface{}, error) {
+	result := []map[string]interface{}{}
+
+	rows, err := db.Query(fmt.Sprintf(`SELECT * FROM "%s";`, table))
+	if err != nil {
+		return result, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return result, err
+	}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return result, err
+		}
+
+		row := make(map[string]interface{})
+		for i, column := range columns {
+			switch value := values[i].(type) {
+			case time.Time:
+				row[column] = value.Format(dbExportTimeFormat)
+			default:
+				row[column] = value
+			}
+		}
+		result = append(result, row)
+	}
+	if err = rows.Err(); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// dbGetColumns returns the column names of a table.
+func dbGetColumns(db *sql.DB, table string) (map[string]bool, error) {
+	columns := make(map[string]bool)
+
+	rows, err := db.Query(`SELECT name FROM pragma_table_info($1);`, table)
+	if err != nil {
+		return columns, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return columns, err
+		}
+		columns[column] = true
+	}
+	if err = rows.Err(); err != nil {
+		return columns, err
+	}
+	return columns, nil
+}
+
+// dbImportTables inserts the rows of all given tables in one transaction.
+// Unless replace is set, all tables have to be empty, otherwise their
+// existing rows are deleted.
+func dbImportTables(db *sql.DB, tables map[string][]map[string]interface{}, replace bool) error {
+	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
-	return nil
+	rollback := func(err error) error {
+		_ = tx.Rollback()
+		return err
+	}
+
+	for table, rows := range tables {
+		columns, err := dbGetColumns(db, table)
+		if err != nil {
+			return rollback(err)
+		}
+		if len(columns) == 0 || table == "sessions" {
+			return rollback(fmt.Errorf("unknown table \"%s\"", table))
+		}
+
+		// settings such as the flag secret are created on startup, so they
+		// are overwritten instead of requiring an empty table
+		insert := "INSERT"
+		if table == "settings" {
+			insert = "INSERT OR REPLACE"
+		}
+
+		if replace {
+			if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM "%s";`, table)); err != nil {
+				return rollback(err)
+			}
+		} else if table != "settings" {
+			var count int
+			if err := tx.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM "%s";`, table)).Scan(&count); err != nil {
+				return rollback(err)
+			}
+			if count > 0 {
+				return rollback(fmt.Errorf("table \"%s\" is not empty", table))
+			}
+		}
+
+		for _, row := range rows {
+			var names, placeholders []string
+			var args []interface{}
+			for column, value := range row {
+				if !columns[column] {
+					return rollback(fmt.Errorf("unknown column \"%s\" in table \"%s\"", column, table))
+				}
+				names = append(names, fmt.Sprintf(`"%s"`, column))
+				placeholders = append(placeholders, "?")
+				args = append(args, value)
+			}
+			query := fmt.Sprintf(`%s INTO "%s" (%s) VALUES(%s);`,
+				insert, table, strings.Join(names, ","), strings.Join(placeholders, ","))
+			if _, err := tx.Exec(query, args...); err != nil {
+				return rollback(err)
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Helpers
+
+// dbInList returns a "(?,?,...)" placeholder list and the matching arguments
+// for use in an IN clause.
+func dbInList(ids []int) (string, []interface{}) {
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+	return "(" + strings.Join(placeholders, ",") + ")", args
+}
+
+// dbScorePoints is the points a row of the score table is worth. Solves of
+// dynamically scored challenges are valued by their current value as given
+// by the dynamic table of dbValues. First blood bonuses are added.
+const dbScorePoints = `(CASE WHEN dynamic.value IS NULL THEN score.points ELSE dynamic.value - score.hintcost END + score.bonus)`
+
+// dbValues returns a WITH clause defining the table dynamic(challenge, value)
+// with the current values of all dynamically scored challenges.
+func dbValues(values map[string]int) (string, []interface{}) {
+	if len(values) == 0 {
+		return `WITH dynamic(challenge, value) AS (SELECT NULL, NULL WHERE 0) `, nil
+	}
+	var rows []string
+	var args []interface{}
+	for challenge, value := range values {
+		rows = append(rows, "(?,?)")
+		args = append(args, challenge, value)
+	}
+	return `WITH dynamic(challenge, value) AS (VALUES ` + strings.Join(rows, ",") + `) `, args
+}
+
+// dbUntil maps the zero time, which means "no limit", to a time after all
+// recorded solves.
+func dbUntil(until time.Time) time.Time {
+	if until.IsZero() {
+		return time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+	}
+	return until.UTC()
 }
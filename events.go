@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"sync"
+	"time"
+)
+
+const (
+	eventSolve        = "solve"
+	eventFirstBlood   = "firstblood"
+	eventAnnouncement = "announcement"
+	eventScoreboard   = "scoreboard"
+)
+
+// eventKeepAlive is the interval in which idle event streams get a comment,
+// so that proxies do not close them and closed connections are noticed.
+const eventKeepAlive = 15 * time.Second
+
+// eventBuffer is the number of events buffered per subscriber. Events for
+// subscribers that do not keep up are dropped.
+const eventBuffer = 16
+
+// event is sent to all subscribers of the eventHub. Title and Text are shown
+// as toast by the browser, events without a title only trigger updates.
+type event struct {
+	Type  string `json:"type"`
+	Title string `json:"title,omitempty"`
+	Text  string `json:"text,omitempty"`
+}
+
+// eventHub is an in-process publish/subscribe hub for live updates.
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan event]struct{}
+	closed      bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subscribers: make(map[chan event]struct{})}
+}
+
+func (h *eventHub) subscribe() chan event {
+	ch := make(chan event, eventBuffer)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(ch)
+		return ch
+	}
+	h.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers, ch)
+}
+
+// close ends all event streams on shutdown. Later subscribers get a closed
+// channel.
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for ch := range h.subscribers {
+		close(ch)
+		delete(h.subscribers, ch)
+	}
+}
+
+// publish sends e to all subscribers without blocking.
+func (h *eventHub) publish(e event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// publicSolve reports whether the solves of u may be announced. Solves of
+// hidden users and solves during the scoreboard freeze are kept private.
+func (ctf *ctf) publicSolve(u user) bool {
+	if ctf.Configuration.frozen() {
+		return false
+	}
+	hidden, err := dbUserIsHidden(ctf.Storage, u.id)
+	return err == nil && !hidden
+}
+
+// publishSolve announces the solve of challengeID by u and the changed
+// scoreboard. blood is the first blood rank of the solve.
+func (ctf *ctf) publishSolve(u user, challengeID string, blood int) {
+	if !ctf.publicSolve(u) {
+		return
+	}
+
+	name, err := u.name()
+	if err != nil {
+		return
+	}
+	if ctf.Configuration.Teams {
+		if team, err := u.teamName(); err == nil {
+			name = team
+		}
+	}
+
+	if blood == 1 {
+		ctf.Events.publish(event{
+			Type:  eventFirstBlood,
+			Title: "First blood",
+			Text:  fmt.Sprintf("%s is the first to solve \"%s\"!", name, ctf.Challenges[challengeID].Title),
+		})
+	} else {
+		ctf.Events.publish(event{
+			Type:  eventSolve,
+			Title: "Challenge solved",
+			Text:  fmt.Sprintf("%s solved \"%s\".", name, ctf.Challenges[challengeID].Title),
+		})
+	}
+	ctf.Events.publish(event{Type: eventScoreboard})
+}
+
+// rGetEvents streams the events of the hub as Server-Sent Events. It must not
+// hold the lock of lockMiddleware, as the stream stays open.
+func rGetEvents(c *fiber.Ctx, ctf *ctf) error {
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	ch := ctf.Events.subscribe()
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer ctf.Events.unsubscribe(ch)
+
+		ticker := time.NewTicker(eventKeepAlive)
+		defer ticker.Stop()
+
+		_, _ = fmt.Fprint(w, ": connected\n\n")
+		if w.Flush() != nil {
+			return
+		}
+
+		for {
+			select {
+			case e, ok := <-ch:
+				if !ok {
+					return
+				}
+				data, err := json.Marshal(e)
+				if err != nil {
+					continue
+				}
+				_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+			case <-ticker.C:
+				_, _ = fmt.Fprint(w, ": keep-alive\n\n")
+			}
+			if w.Flush() != nil {
+				return
+			}
+		}
+	})
+	return nil
+}
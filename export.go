@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// exportFormat is the format of database exports.
+type exportFormat struct {
+	Version int                                 `json:"version"`
+	Time    time.Time                           `json:"time"`
+	Tables  map[string][]map[string]interface{} `json:"tables"`
+}
+
+const exportVersion = 1
+
+// exportBlobKey marks BLOB values, which are exported as {"$base64": "..."},
+// since JSON strings cannot hold arbitrary bytes, e.g. legacy password hashes.
+const exportBlobKey = "$base64"
+
+// exportDB returns all tables except the sessions.
+func (ctf *ctf) exportDB() (exportFormat, error) {
+	export := exportFormat{Version: exportVersion, Time: time.Now(), Tables: make(map[string][]map[string]interface{})}
+
+	tables, err := dbGetTables(ctf.Storage)
+	if err != nil {
+		return export, err
+	}
+	for _, table := range tables {
+		rows, err := dbGetTableRows(ctf.Storage, table)
+		if err != nil {
+			return export, fmt.Errorf("table \"%s\": %s", table, err)
+		}
+		for _, row := range rows {
+			for column, value := range row {
+				if blob, ok := value.([]byte); ok {
+					row[column] = map[string]string{exportBlobKey: base64.StdEncoding.EncodeToString(blob)}
+				}
+			}
+		}
+		export.Tables[table] = rows
+	}
+	return export, nil
+}
+
+func writeExport(w io.Writer, export exportFormat) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(export)
+}
+
+func readExport(r io.Reader) (exportFormat, error) {
+	var export exportFormat
+	decoder := json.NewDecoder(r)
+	// numbers are kept as json.Number to not lose integer precision
+	decoder.UseNumber()
+	if err := decoder.Decode(&export); err != nil {
+		return export, err
+	}
+	if export.Version != exportVersion {
+		return export, fmt.Errorf("unsupported export version %d", export.Version)
+	}
+
+	for table, rows := range export.Tables {
+		for _, row := range rows {
+			for column, value := range row {
+				imported, err := importValue(value)
+				if err != nil {
+					return export, fmt.Errorf("table \"%s\", column \"%s\": %s", table, column, err)
+				}
+				row[column] = imported
+			}
+		}
+	}
+	return export, nil
+}
+
+// importValue converts a decoded JSON value back to the value stored in the
+// database.
+func importValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		return v.Float64()
+	case map[string]interface{}:
+		encoded, ok := v[exportBlobKey].(string)
+		if !ok || len(v) != 1 {
+			return nil, fmt.Errorf("unexpected object")
+		}
+		return base64.StdEncoding.DecodeString(encoded)
+	case []interface{}:
+		return nil, fmt.Errorf("unexpected list")
+	default:
+		return value, nil
+	}
+}
+
+// importDB inserts the tables of an export, see dbImportTables.
+func (ctf *ctf) importDB(export exportFormat, replace bool) error {
+	return dbImportTables(ctf.Storage, export.Tables, replace)
+}
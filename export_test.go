@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestExportRoundTrip(t *testing.T) {
+	const conf = "title: test\nsessionTimeout: 600\n"
+	source := newTestCTF(t, conf, nil)
+
+	// legacy hashes are raw sha256 sums, pick a salt that makes it invalid UTF-8
+	var salt string
+	var legacyHash [sha256.Size]byte
+	for i := 0; ; i++ {
+		salt = fmt.Sprintf("salt%d", i)
+		legacyHash = sha256.Sum256([]byte("legacy password" + salt))
+		if !utf8.Valid(legacyHash[:]) {
+			break
+		}
+	}
+	if _, err := source.Storage.Exec("INSERT INTO users (name, password, salt) VALUES(?,?,?);",
+		"legacy", legacyHash[:], salt); err != nil {
+		t.Fatal(err)
+	}
+	id, err := source.createUser("alice", "alice password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := dbChallengeAddSolve(source.Storage, id, "example", 100, 10, firstBloodBonus{50}); err != nil {
+		t.Fatal(err)
+	}
+
+	export, err := source.exportDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := writeExport(&buf, export); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := readExport(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := newTestCTF(t, conf, nil)
+	if err := target.importDB(imported, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, password := range map[string]string{"legacy": "legacy password", "alice": "alice password"} {
+		_, hash, salt, err := dbUserGetPassword(target.Storage, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, _, err := verifyPassword(password, hash, salt); err != nil || !ok {
+			t.Errorf("password of %s does not verify after the import: %v", name, err)
+		}
+	}
+
+	reexport, err := target.exportDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(export.Tables, reexport.Tables) {
+		t.Errorf("tables differ after the round trip:\n%v\n%v", export.Tables, reexport.Tables)
+	}
+}
+
+func TestImportNotEmpty(t *testing.T) {
+	const conf = "title: test\nsessionTimeout: 600\n"
+	source := newTestCTF(t, conf, nil)
+	if _, err := source.createUser("alice", "password"); err != nil {
+		t.Fatal(err)
+	}
+	export, err := source.exportDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := newTestCTF(t, conf, nil)
+	if _, err := target.createUser("bob", "password"); err != nil {
+		t.Fatal(err)
+	}
+	if err := target.importDB(export, false); err == nil {
+		t.Fatal("import into a table with rows succeeded without replace")
+	}
+	if err := target.importDB(export, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dbUserGetID(target.Storage, "bob"); err == nil {
+		t.Error("replace kept the existing rows")
+	}
+}
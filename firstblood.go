@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+)
+
+// firstBloodRanks is the number of first solves recorded per challenge.
+const firstBloodRanks = 3
+
+// firstBloodBonus are the bonus points of the first, second and third solve
+// of a challenge. In ctf.yml and challenge.yml it can be given as a single
+// number for the first solve or as a list, e.g.
+//
+//	firstBloodBonus: [50, 25, 10]
+type firstBloodBonus []int
+
+func (b *firstBloodBonus) UnmarshalYAML(node *yaml.Node) error {
+	var bonus []int
+
+	switch node.Kind {
+	case yaml.ScalarNode:
+		var first int
+		if err := node.Decode(&first); err != nil {
+			return err
+		}
+		bonus = []int{first}
+	case yaml.SequenceNode:
+		if err := node.Decode(&bonus); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("line %d: first blood bonus must be a number or a list", node.Line)
+	}
+
+	if len(bonus) > firstBloodRanks {
+		return fmt.Errorf("line %d: first blood bonus is limited to %d solves", node.Line, firstBloodRanks)
+	}
+	*b = bonus
+	return nil
+}
+
+// points returns the bonus of the solve with the given rank, starting at 1.
+func (b firstBloodBonus) points(rank int) int {
+	if rank < 1 || rank > len(b) {
+		return 0
+	}
+	return b[rank-1]
+}
+
+// firstBloodBonus returns the first blood bonus of a challenge. The bonus of
+// the challenge takes precedence over the one of ctf.yml.
+func (ctf *ctf) firstBloodBonus(challengeID string) firstBloodBonus {
+	if bonus := ctf.Challenges[challengeID].FirstBloodBonus; bonus != nil {
+		return bonus
+	}
+	return ctf.Configuration.FirstBloodBonus
+}
+
+// firstBloods returns the names of the first solvers of every challenge, in
+// the order of their solves. Unless live is set, solves after the configured
+// freeze are not taken into account.
+func (ctf *ctf) firstBloods(live bool) (map[string][]string, error) {
+	bloods := make(map[string][]string)
+
+	rows, err := dbGetFirstBloods(ctf.Storage, ctf.scoreboardUntil(live), ctf.Configuration.Teams)
+	if err != nil {
+		return bloods, err
+	}
+
+	for _, row := range rows {
+		bloods[row[0].(string)] = append(bloods[row[0].(string)], row[2].(string))
+	}
+	return bloods, nil
+}
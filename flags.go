@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"regexp"
+	"strings"
+)
+
+const (
+	flagTypeStatic          = "static"
+	flagTypeCaseInsensitive = "case-insensitive"
+	flagTypeRegex           = "regex"
+	flagTypeDynamic         = "dynamic"
+)
+
+// flagPlaceholder is replaced by a per player token in the template of
+// dynamic flags.
+const flagPlaceholder = "..."
+
+type challengeFlag struct {
+	Value    string `yaml:"value"`
+	Type     string `yaml:"type"`
+	Template string `yaml:"template"`
+	regex    *regexp.Regexp
+}
+
+// flagExpander returns the concrete flag of a player for the template of a
+// dynamic flag.
+type flagExpander func(template string) string
+
+// challengeFlags are the accepted flags of a challenge. In challenge.yml they
+// can be given as a single string, a single flag or a list of both, e.g.
+//
+//	flag:
+//	  - CTF{static}
+//	  - value: ctf{any case}
+//	    type: case-insensitive
+//	  - value: CTF\{[0-9]+\}
+//	    type: regex
+//	  - template: CTF{...}
+//	    type: dynamic
+type challengeFlags []challengeFlag
+
+func (f *challengeFlags) UnmarshalYAML(node *yaml.Node) error {
+	var flags challengeFlags
+
+	nodes := []*yaml.Node{node}
+	if node.Kind == yaml.SequenceNode {
+		nodes = node.Content
+	}
+
+	for _, n := range nodes {
+		var flag challengeFlag
+		switch n.Kind {
+		case yaml.ScalarNode:
+			flag.Value = n.Value
+		case yaml.MappingNode:
+			type plain challengeFlag
+			if err := n.Decode((*plain)(&flag)); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("line %d: flag must be a string or a mapping", n.Line)
+		}
+		if err := flag.init(); err != nil {
+			return fmt.Errorf("line %d: %s", n.Line, err)
+		}
+		flags = append(flags, flag)
+	}
+
+	*f = flags
+	return nil
+}
+
+func (f *challengeFlag) init() error {
+	switch f.Type {
+	case "":
+		f.Type = flagTypeStatic
+	case flagTypeStatic, flagTypeCaseInsensitive:
+	case flagTypeRegex:
+		regex, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", f.Value))
+		if err != nil {
+			return err
+		}
+		f.regex = regex
+	case flagTypeDynamic:
+		if f.Template == "" {
+			return fmt.Errorf("dynamic flag without template")
+		}
+	default:
+		return fmt.Errorf("unknown flag type \"%s\"", f.Type)
+	}
+	return nil
+}
+
+func (f *challengeFlag) matches(submitted string, expand flagExpander) bool {
+	submitted = strings.TrimSpace(submitted)
+	switch f.Type {
+	case flagTypeDynamic:
+		return strings.Compare(strings.TrimSpace(expand(f.Template)), submitted) == 0
+	case flagTypeCaseInsensitive:
+		return strings.EqualFold(strings.TrimSpace(f.Value), submitted)
+	case flagTypeRegex:
+		return f.regex != nil && f.regex.MatchString(submitted)
+	default:
+		return strings.Compare(strings.TrimSpace(f.Value), submitted) == 0
+	}
+}
+
+// matches reports whether submitted is one of the accepted flags. Dynamic
+// flags are expanded by expand.
+func (f challengeFlags) matches(submitted string, expand flagExpander) bool {
+	for _, flag := range f {
+		if flag.matches(submitted, expand) {
+			return true
+		}
+	}
+	return false
+}
+
+// dynamic reports whether f contains a dynamic flag.
+func (f challengeFlags) dynamic() bool {
+	for _, flag := range f {
+		if flag.Type == flagTypeDynamic {
+			return true
+		}
+	}
+	return false
+}
+
+// first returns the first accepted flag, e.g. for use in file templates.
+// Regular expressions are returned as they are.
+func (f challengeFlags) first(expand flagExpander) string {
+	if len(f) == 0 {
+		return ""
+	}
+	if f[0].Type == flagTypeDynamic {
+		return expand(f[0].Template)
+	}
+	return f[0].Value
+}
+
+// dynamicFlag expands a dynamic flag template for a player. The token is
+// derived from the CTF secret, the challenge and the player so that players
+// cannot guess the flags of others.
+func dynamicFlag(secret, challengeID, player, template string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(challengeID + "/" + player))
+	token := hex.EncodeToString(mac.Sum(nil))[:16]
+
+	if !strings.Contains(template, flagPlaceholder) {
+		return template + token
+	}
+	return strings.Replace(template, flagPlaceholder, token, 1)
+}
+
+// initFlagSecret returns the secret used for dynamic flags, generating and
+// storing one on first use.
+func initFlagSecret(db *sql.DB) (string, error) {
+	secret, err := dbGetSetting(db, "flagSecret")
+	if err == nil {
+		return secret, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", err
+	}
+
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return "", err
+	}
+	secret = hex.EncodeToString(b)
+	return secret, dbSetSetting(db, "flagSecret", secret)
+}
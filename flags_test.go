@@ -0,0 +1,72 @@
+package main
+
+import (
+	"gopkg.in/yaml.v3"
+	"strings"
+	"testing"
+)
+
+func TestFlagMatches(t *testing.T) {
+	expand := func(template string) string {
+		return dynamicFlag("secret", "challenge", "u1", template)
+	}
+	other := func(template string) string {
+		return dynamicFlag("secret", "challenge", "u2", template)
+	}
+
+	for _, test := range []struct {
+		name      string
+		flag      string
+		submitted string
+		want      bool
+	}{
+		{"static", "flag: CTF{static}", "CTF{static}", true},
+		{"static surrounding space", "flag: CTF{static}", "  CTF{static}\n", true},
+		{"static wrong case", "flag: CTF{static}", "ctf{STATIC}", false},
+		{"static prefix", "flag: CTF{static}", "CTF{stat", false},
+		{"static mapping", "flag:\n  value: CTF{static}\n  type: static", "CTF{static}", true},
+		{"case-insensitive", "flag:\n  value: CTF{Case}\n  type: case-insensitive", "ctf{cASE}", true},
+		{"case-insensitive wrong", "flag:\n  value: CTF{Case}\n  type: case-insensitive", "ctf{other}", false},
+		{"regex", "flag:\n  value: CTF\\{[0-9]+\\}\n  type: regex", "CTF{1337}", true},
+		{"regex no match", "flag:\n  value: CTF\\{[0-9]+\\}\n  type: regex", "CTF{leet}", false},
+		{"regex anchored start", "flag:\n  value: CTF\\{[0-9]+\\}\n  type: regex", "xCTF{1337}", false},
+		{"regex anchored end", "flag:\n  value: CTF\\{[0-9]+\\}\n  type: regex", "CTF{1337}x", false},
+		{"regex alternation anchored", "flag:\n  value: a|b\n  type: regex", "ab", false},
+		{"regex alternation", "flag:\n  value: a|b\n  type: regex", "b", true},
+		{"multiple first", "flag:\n  - CTF{one}\n  - value: ctf{two}\n    type: case-insensitive", "CTF{one}", true},
+		{"multiple second", "flag:\n  - CTF{one}\n  - value: ctf{two}\n    type: case-insensitive", "CTF{TWO}", true},
+		{"multiple none", "flag:\n  - CTF{one}\n  - value: ctf{two}\n    type: case-insensitive", "CTF{three}", false},
+		{"dynamic own", "flag:\n  template: CTF{...}\n  type: dynamic", expand("CTF{...}"), true},
+		{"dynamic other player", "flag:\n  template: CTF{...}\n  type: dynamic", other("CTF{...}"), false},
+		{"dynamic template", "flag:\n  template: CTF{...}\n  type: dynamic", "CTF{...}", false},
+	} {
+		var cha challenge
+		if err := yaml.Unmarshal([]byte(test.flag), &cha); err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if got := cha.Flag.matches(test.submitted, expand); got != test.want {
+			t.Errorf("%s: matches(%q) = %v, want %v", test.name, test.submitted, got, test.want)
+		}
+	}
+}
+
+func TestFlagInvalid(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		flag string
+		err  string
+	}{
+		{"invalid regex", "flag:\n  value: CTF{[0-9\n  type: regex", "line 2: error parsing regexp"},
+		{"invalid regex in list", "flag:\n  - CTF{ok}\n  - value: (\n    type: regex", "line 3: error parsing regexp"},
+		{"unknown type", "flag:\n  value: CTF{x}\n  type: glob", `unknown flag type "glob"`},
+		{"dynamic without template", "flag:\n  type: dynamic", "dynamic flag without template"},
+		{"list in list", "flag:\n  - [one, two]", "flag must be a string or a mapping"},
+	} {
+		var cha challenge
+		err := yaml.Unmarshal([]byte(test.flag), &cha)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	healthTypeTCP    = "tcp"
+	healthTypeHTTP   = "http"
+	healthTypeExpect = "expect"
+)
+
+// healthTick is the interval in which due health checks are started.
+const healthTick = 5 * time.Second
+
+// defaultHealthInterval is the interval of health checks without interval.
+const defaultHealthInterval = 30 * time.Second
+
+// healthTimeout limits a single health check.
+const healthTimeout = 5 * time.Second
+
+// healthHistory is the number of results kept per challenge.
+const healthHistory = 50
+
+// healthExpectLimit is the number of bytes read by expect checks.
+const healthExpectLimit = 4096
+
+// challengeHealth configures the health check of a challenge service, e.g.
+//
+//	health:
+//	  type: http
+//	  path: /
+//	  status: 200
+//
+// tcp checks only connect, expect checks wait for Expect to be received.
+type challengeHealth struct {
+	Type     string `yaml:"type"`
+	Host     string `yaml:"host"`
+	Path     string `yaml:"path"`
+	Status   int    `yaml:"status"`
+	Expect   string `yaml:"expect"`
+	Interval int    `yaml:"interval"`
+}
+
+func (h challengeHealth) interval() time.Duration {
+	if h.Interval > 0 {
+		return time.Duration(h.Interval) * time.Second
+	}
+	return defaultHealthInterval
+}
+
+// check runs the health check against the service at address.
+func (h challengeHealth) check(ctx context.Context, address string) error {
+	switch h.Type {
+	case healthTypeHTTP:
+		path := h.Path
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+address+path, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+
+		status := h.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if resp.StatusCode != status {
+			return fmt.Errorf("got status %d instead of %d", resp.StatusCode, status)
+		}
+		return nil
+	case healthTypeTCP, healthTypeExpect:
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+		if h.Type == healthTypeTCP {
+			return nil
+		}
+
+		if deadline, ok := ctx.Deadline(); ok {
+			_ = conn.SetReadDeadline(deadline)
+		}
+		var received []byte
+		r := bufio.NewReader(conn)
+		for len(received) < healthExpectLimit {
+			b, err := r.ReadByte()
+			if err != nil {
+				return fmt.Errorf("\"%s\" not received: %s", h.Expect, err)
+			}
+			received = append(received, b)
+			if strings.Contains(string(received), h.Expect) {
+				return nil
+			}
+		}
+		return fmt.Errorf("\"%s\" not received", h.Expect)
+	default:
+		return fmt.Errorf("unknown health check type \"%s\"", h.Type)
+	}
+}
+
+type healthResult struct {
+	Up    bool
+	Time  time.Time
+	Error string
+}
+
+type healthStatus struct {
+	Challenge string
+	History   []healthResult
+}
+
+// Last returns the latest result.
+func (s healthStatus) Last() healthResult {
+	if len(s.History) == 0 {
+		return healthResult{}
+	}
+	return s.History[len(s.History)-1]
+}
+
+// healthChecker keeps the results of the health checks of all services.
+type healthChecker struct {
+	mu       sync.Mutex
+	statuses map[string]*healthStatus
+	running  map[string]bool
+}
+
+func newHealthChecker() *healthChecker {
+	return &healthChecker{statuses: make(map[string]*healthStatus), running: make(map[string]bool)}
+}
+
+func (h *healthChecker) add(challengeID string, result healthResult) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.statuses[challengeID]
+	if !ok {
+		s = &healthStatus{Challenge: challengeID}
+		h.statuses[challengeID] = s
+	}
+	s.History = append(s.History, result)
+	if len(s.History) > healthHistory {
+		s.History = s.History[len(s.History)-healthHistory:]
+	}
+	delete(h.running, challengeID)
+}
+
+// due reports whether a check is due and marks it as running if so.
+func (h *healthChecker) due(challengeID string, interval time.Duration) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.running[challengeID] {
+		return false
+	}
+	if s, ok := h.statuses[challengeID]; ok && len(s.History) > 0 &&
+		time.Since(s.History[len(s.History)-1].Time) < interval {
+		return false
+	}
+	h.running[challengeID] = true
+	return true
+}
+
+// status returns a copy of the status of a challenge and whether it was
+// checked at all.
+func (h *healthChecker) status(challengeID string) (healthStatus, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.statuses[challengeID]
+	if !ok {
+		return healthStatus{}, false
+	}
+	return healthStatus{Challenge: s.Challenge, History: append([]healthResult{}, s.History...)}, true
+}
+
+func (h *healthChecker) list() []healthStatus {
+	h.mu.Lock()
+	var ids []string
+	for id := range h.statuses {
+		ids = append(ids, id)
+	}
+	h.mu.Unlock()
+
+	sort.Strings(ids)
+	var statuses []healthStatus
+	for _, id := range ids {
+		if s, ok := h.status(id); ok {
+			statuses = append(statuses, s)
+		}
+	}
+	return statuses
+}
+
+// checkHealth starts the due health checks of all shared services.
+func (ctf *ctf) checkHealth() {
+	type target struct {
+		id      string
+		address string
+		health  challengeHealth
+	}
+	var targets []target
+
+	ctf.lock.RLock()
+	for id, cha := range ctf.Challenges {
+		h := cha.Service.Health
+		if h.Type == "" || cha.Service.Port == 0 || cha.Service.Instanced {
+			continue
+		}
+		host := h.Host
+		if host == "" {
+			host = ctf.Configuration.ServiceHost
+		}
+		targets = append(targets, target{id, net.JoinHostPort(host, strconv.Itoa(cha.Service.Port)), h})
+	}
+	ctf.lock.RUnlock()
+
+	for _, t := range targets {
+		if !ctf.Health.due(t.id, t.health.interval()) {
+			continue
+		}
+		go func(t target) {
+			ctx, cancel := context.WithTimeout(context.Background(), healthTimeout)
+			defer cancel()
+
+			result := healthResult{Up: true, Time: time.Now()}
+			if err := t.health.check(ctx, t.address); err != nil {
+				result.Up, result.Error = false, err.Error()
+			}
+
+			if last, ok := ctf.Health.status(t.id); ok && last.Last().Up != result.Up {
+				fmt.Printf("[INFO] service \"%s\" is %s\n", t.id, map[bool]string{true: "up", false: "down"}[result.Up])
+			}
+			ctf.Health.add(t.id, result)
+		}(t)
+	}
+}
+
+// watchHealth runs the health checks until ctx is done.
+func (ctf *ctf) watchHealth(ctx context.Context) {
+	ticker := time.NewTicker(healthTick)
+	defer ticker.Stop()
+	for {
+		ctf.checkHealth()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func rGetAdminHealth(c *fiber.Ctx, ctf *ctf) error {
+	return renderWithSession(c, *ctf, "admin/health", fiber.Map{
+		"Statuses": ctf.Health.list(),
+	})
+}
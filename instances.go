@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"time"
+)
+
+// defaultInstanceTTL is the lifetime of instances of services without ttl.
+const defaultInstanceTTL = 30 * time.Minute
+
+// defaultInstancePorts is the range of host ports used for instances unless
+// instancePorts is set in ctf.yml.
+var defaultInstancePorts = []int{40000, 40999}
+
+type instance struct {
+	Player    string
+	Challenge string
+	Port      int
+	Expires   time.Time
+}
+
+// Remaining returns the remaining lifetime in minutes, rounded up.
+func (i instance) Remaining() int {
+	return int((time.Until(i.Expires) + time.Minute - 1) / time.Minute)
+}
+
+func instanceName(challengeID, player string) string {
+	return serviceName(challengeID) + "-" + player
+}
+
+func (cha challenge) instanceTTL() time.Duration {
+	if cha.Service.TTL > 0 {
+		return time.Duration(cha.Service.TTL) * time.Second
+	}
+	return defaultInstanceTTL
+}
+
+func (ctf *ctf) instances() ([]instance, error) {
+	var instances []instance
+
+	rows, err := dbGetInstances(ctf.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		instances = append(instances, instance{
+			Player:    row[0].(string),
+			Challenge: row[1].(string),
+			Port:      row[2].(int),
+			Expires:   row[3].(time.Time),
+		})
+	}
+	return instances, nil
+}
+
+// instance returns the running instance of a challenge of the session user
+// or their team.
+func (ctf *ctf) instance(c *fiber.Ctx, challengeID string) (instance, bool) {
+	u, err := ctf.ensureLoggedIn(c)
+	if err != nil {
+		return instance{}, false
+	}
+	player, err := u.playerKey()
+	if err != nil {
+		return instance{}, false
+	}
+
+	instances, err := ctf.instances()
+	if err != nil {
+		return instance{}, false
+	}
+	for _, i := range instances {
+		if i.Player == player && i.Challenge == challengeID && time.Now().Before(i.Expires) {
+			return i, true
+		}
+	}
+	return instance{}, false
+}
+
+// instanceChallenge checks that the session user may run an instance of the
+// challenge and returns it with the user and its player key. It is called
+// without ctf.lock, which it only holds while reading the challenges.
+func (ctf *ctf) instanceChallenge(c *fiber.Ctx, challengeID string) (challenge, user, string, error) {
+	u, err := ctf.ensureLoggedIn(c)
+	if err != nil {
+		return challenge{}, user{}, "", err
+	}
+
+	ctf.lock.RLock()
+	defer ctf.lock.RUnlock()
+
+	if err = ctf.running(); err != nil {
+		return challenge{}, user{}, "", err
+	}
+	if ctf.isLocked(c, challengeID) {
+		return challenge{}, user{}, "", errChallengeLocked
+	}
+
+	cha, ok := ctf.Challenges[challengeID]
+	if !ok || !cha.Service.Instanced || ctf.Services == nil {
+		return challenge{}, user{}, "", fmt.Errorf("challenge has no instances")
+	}
+
+	player, err := u.playerKey()
+	if err != nil {
+		return challenge{}, user{}, "", err
+	}
+	return cha, u, player, nil
+}
+
+// freeInstancePort returns a host port of the configured range that is not
+// used by another instance.
+func (ctf *ctf) freeInstancePort() (int, error) {
+	ports := ctf.Configuration.InstancePorts
+	if len(ports) != 2 {
+		ports = defaultInstancePorts
+	}
+
+	instances, err := ctf.instances()
+	if err != nil {
+		return 0, err
+	}
+	used := make(map[int]bool, len(instances))
+	for _, i := range instances {
+		used[i.Port] = true
+	}
+
+	for port := ports[0]; port <= ports[1]; port++ {
+		if !used[port] {
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("all instance ports are in use")
+}
+
+// startInstance starts a private instance of a challenge for the session
+// user or their team. The flag of the player is passed to the container as
+// environment variable FLAG. Starting the container can take long, so the
+// port is reserved under m.instances and the container is started without
+// holding any lock.
+func (ctf *ctf) startInstance(c *fiber.Ctx, challengeID string) (instance, error) {
+	cha, u, player, err := ctf.instanceChallenge(c, challengeID)
+	if err != nil {
+		return instance{}, err
+	}
+
+	m := ctf.Services
+	s := m.status(challengeID)
+	m.mu.Lock()
+	built := s.built
+	m.mu.Unlock()
+	if !built {
+		return instance{}, fmt.Errorf("the service is not ready yet")
+	}
+
+	ctf.lock.RLock()
+	expand, err := ctf.flagExpander(u, challengeID)
+	ctf.lock.RUnlock()
+	if err != nil {
+		return instance{}, err
+	}
+
+	i, running, err := ctf.reserveInstance(c, cha, challengeID, player)
+	if err != nil || running {
+		return i, err
+	}
+
+	name := instanceName(challengeID, player)
+	ctx, cancel := context.WithTimeout(context.Background(), serviceTimeout)
+	defer cancel()
+	err = m.runtime.start(ctx, containerSpec{
+		Name:     name,
+		Image:    serviceName(challengeID),
+		Port:     cha.Service.Port,
+		HostPort: i.Port,
+		Env:      map[string]string{"FLAG": cha.Flag.first(expand)},
+	})
+	if err != nil {
+		fmt.Printf("[ERROR] could not start instance \"%s\": %s!\n", name, err)
+		_ = dbDeleteInstance(ctf.Storage, player, challengeID)
+		return instance{}, err
+	}
+
+	fmt.Printf("[INFO] started instance \"%s\" on port %d\n", name, i.Port)
+	return i, nil
+}
+
+// reserveInstance stores a new instance with a free port. If the player
+// already has a running instance, it is returned instead.
+func (ctf *ctf) reserveInstance(c *fiber.Ctx, cha challenge, challengeID, player string) (instance, bool, error) {
+	m := ctf.Services
+	m.instances.Lock()
+	defer m.instances.Unlock()
+
+	if i, ok := ctf.instance(c, challengeID); ok {
+		return i, true, nil
+	}
+
+	// an expired instance may not be reaped yet
+	_ = dbDeleteInstance(ctf.Storage, player, challengeID)
+
+	ctf.lock.RLock()
+	port, err := ctf.freeInstancePort()
+	ctf.lock.RUnlock()
+	if err != nil {
+		return instance{}, false, err
+	}
+
+	i := instance{Player: player, Challenge: challengeID, Port: port, Expires: time.Now().Add(cha.instanceTTL())}
+	if err = dbInsertInstance(ctf.Storage, i.Player, i.Challenge, i.Port, i.Expires); err != nil {
+		return instance{}, false, err
+	}
+	return i, false, nil
+}
+
+// extendInstance resets the lifetime of the instance of the session user.
+func (ctf *ctf) extendInstance(c *fiber.Ctx, challengeID string) error {
+	cha, _, player, err := ctf.instanceChallenge(c, challengeID)
+	if err != nil {
+		return err
+	}
+	if _, ok := ctf.instance(c, challengeID); !ok {
+		return fmt.Errorf("no instance is running")
+	}
+	return dbSetInstanceExpires(ctf.Storage, player, challengeID, time.Now().Add(cha.instanceTTL()))
+}
+
+// stopInstance stops the instance of the session user, it is called without
+// ctf.lock.
+func (ctf *ctf) stopInstance(c *fiber.Ctx, challengeID string) error {
+	if ctf.Services == nil {
+		return fmt.Errorf("challenge has no instances")
+	}
+	u, err := ctf.ensureLoggedIn(c)
+	if err != nil {
+		return err
+	}
+	player, err := u.playerKey()
+	if err != nil {
+		return err
+	}
+	return ctf.removeInstance(player, challengeID)
+}
+
+func (ctf *ctf) removeInstance(player, challengeID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), serviceTimeout)
+	defer cancel()
+
+	name := instanceName(challengeID, player)
+	if err := ctf.Services.runtime.stop(ctx, name); err != nil {
+		fmt.Printf("[ERROR] could not stop instance \"%s\": %s!\n", name, err)
+		return err
+	}
+	return dbDeleteInstance(ctf.Storage, player, challengeID)
+}
+
+// reapInstances stops all expired instances.
+func (ctf *ctf) reapInstances() {
+	instances, err := ctf.instances()
+	if err != nil {
+		return
+	}
+	for _, i := range instances {
+		if time.Now().After(i.Expires) {
+			if ctf.removeInstance(i.Player, i.Challenge) == nil {
+				fmt.Printf("[INFO] stopped expired instance \"%s\"\n", instanceName(i.Challenge, i.Player))
+			}
+		}
+	}
+}
+
+func (ctf *ctf) stopInstances() {
+	instances, err := ctf.instances()
+	if err != nil {
+		return
+	}
+	for _, i := range instances {
+		_ = ctf.removeInstance(i.Player, i.Challenge)
+	}
+}
+
+func rPostChallengeInstance(c *fiber.Ctx, ctf *ctf) error {
+	var err error
+	switch c.Params("action") {
+	case "start":
+		_, err = ctf.startInstance(c, c.Params("challengePath"))
+	case "extend":
+		err = ctf.extendInstance(c, c.Params("challengePath"))
+	case "stop":
+		err = ctf.stopInstance(c, c.Params("challengePath"))
+	default:
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	if err != nil {
+		ctf.addToast(c, "Instance failed",
+			fmt.Sprintf("The instance could not be changed: %s.", err))
+	}
+	return c.Redirect(fmt.Sprintf("/challenges/%s", c.Params("challengePath")))
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestInstanceLifecycle(t *testing.T) {
+	ctf := newTestCTF(t, serviceTestConf+"instancePorts: [40000, 40009]\n", map[string]string{
+		"pwn": "name: pwn\nflag: CTF{pwn}\nservice:\n  port: 1337\n  instanced: true\n",
+	})
+	fake := ctf.Services.runtime.(*fakeRuntime)
+	ctf.checkServices()
+
+	app := fiber.New()
+	app.Post("/signup", func(c *fiber.Ctx) error {
+		_, err := ctf.register(c, "alice", "password", "password", "")
+		return err
+	})
+	app.Post("/instance/:action", func(c *fiber.Ctx) error {
+		switch c.Params("action") {
+		case "start":
+			i, err := ctf.startInstance(c, "pwn")
+			if err != nil {
+				return err
+			}
+			return c.SendString(fmt.Sprint(i.Port))
+		case "stop":
+			return ctf.stopInstance(c, "pwn")
+		}
+		return fiber.ErrNotFound
+	})
+	app.Get("/api/challenges/:challengePath", func(c *fiber.Ctx) error {
+		return rGetAPIChallenge(c, ctf)
+	})
+
+	resp, err := app.Test(httptest.NewRequest("POST", "/signup", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := resp.Cookies()
+
+	// concurrent starts of the same player share one instance
+	post := func(action string) string {
+		req := httptest.NewRequest("POST", "/instance/"+action, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Error(err)
+			return ""
+		}
+		body := make([]byte, 16)
+		n, _ := resp.Body.Read(body)
+		if resp.StatusCode != fiber.StatusOK {
+			t.Errorf("%s failed with status %d: %s", action, resp.StatusCode, body[:n])
+		}
+		return string(body[:n])
+	}
+	// the API reports the port of the instance, not the one in the container
+	apiService := func() apiChallenge {
+		req := httptest.NewRequest("GET", "/api/challenges/pwn", nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result apiChallenge
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+	if result := apiService(); !result.Instanced || result.Service != "" {
+		t.Fatalf("API reports service %q without instance", result.Service)
+	}
+
+	ports := make([]string, 4)
+	var wg sync.WaitGroup
+	for i := range ports {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ports[i] = post("start")
+		}(i)
+	}
+	wg.Wait()
+	for _, port := range ports {
+		if port != "40000" {
+			t.Fatalf("instances got ports %v, want all 40000", ports)
+		}
+	}
+
+	instances, err := ctf.instances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 1 {
+		t.Fatalf("%d instances stored, want 1", len(instances))
+	}
+	name := instanceName("pwn", instances[0].Player)
+	if got := fakeState(t, ctf, name); got != containerRunning {
+		t.Fatalf("instance is %s after starting it", got)
+	}
+	fake.mu.Lock()
+	spec := fake.containers[name].spec
+	fake.mu.Unlock()
+	if spec.HostPort != 40000 || spec.Port != 1337 || spec.Env["FLAG"] != "CTF{pwn}" {
+		t.Fatalf("unexpected container %+v", spec)
+	}
+
+	if result := apiService(); result.Service != fmt.Sprintf("%s:40000", ctf.Configuration.ServiceHost) {
+		t.Fatalf("API reports service %q for the instance on port 40000", result.Service)
+	}
+
+	post("stop")
+	if got := fakeState(t, ctf, name); got != containerMissing {
+		t.Fatalf("instance is %s after stopping it", got)
+	}
+	if instances, _ = ctf.instances(); len(instances) != 0 {
+		t.Fatalf("%d instances stored after stopping, want 0", len(instances))
+	}
+}
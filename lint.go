@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type lintIssue struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (i lintIssue) String() string {
+	if i.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", i.File, i.Line, i.Message)
+	}
+	return fmt.Sprintf("%s: %s", i.File, i.Message)
+}
+
+var (
+	yamlLinePattern       = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+	yamlUnknownKeyPattern = regexp.MustCompile(`^field (.*) not found in type .*$`)
+)
+
+// lintYAML strictly decodes the YAML file at path into out and reports
+// syntax errors, type errors and unknown keys. The parsed document is
+// returned for looking up the lines of keys.
+func lintYAML(path string, out interface{}) (*yaml.Node, []lintIssue) {
+	var issues []lintIssue
+
+	f, err := os.ReadFile(path)
+	if err != nil {
+		return nil, []lintIssue{{File: path, Message: err.Error()}}
+	}
+
+	var doc yaml.Node
+	if err = yaml.Unmarshal(f, &doc); err != nil {
+		return nil, []lintIssue{yamlIssue(path, err.Error())}
+	}
+	if len(doc.Content) == 0 {
+		return &doc, []lintIssue{{File: path, Message: "file is empty"}}
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(f))
+	dec.KnownFields(true)
+	err = dec.Decode(out)
+	var typeErr *yaml.TypeError
+	switch {
+	case err == nil:
+	case errors.As(err, &typeErr):
+		for _, msg := range typeErr.Errors {
+			issues = append(issues, yamlIssue(path, msg))
+		}
+	default:
+		issues = append(issues, yamlIssue(path, err.Error()))
+	}
+
+	return &doc, issues
+}
+
+func yamlIssue(path, msg string) lintIssue {
+	issue := lintIssue{File: path, Message: msg}
+	if m := yamlLinePattern.FindStringSubmatch(msg); m != nil {
+		issue.Line, _ = strconv.Atoi(m[1])
+		issue.Message = m[2]
+	}
+	if m := yamlUnknownKeyPattern.FindStringSubmatch(issue.Message); m != nil {
+		issue.Message = fmt.Sprintf("unknown key \"%s\"", m[1])
+	}
+	return issue
+}
+
+// keyLine returns the line of a key of doc given by its dot separated path,
+// e.g. "service.health.type", where list items are selected by their index.
+// If the path is only partially present, the line of the deepest present key
+// is returned and 0 if not even the first key is present.
+func keyLine(doc *yaml.Node, path string) int {
+	if doc == nil || len(doc.Content) == 0 {
+		return 0
+	}
+
+	line := 0
+	node := doc.Content[0]
+	for _, key := range strings.Split(path, ".") {
+		var next *yaml.Node
+		switch node.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == key {
+					line, next = node.Content[i].Line, node.Content[i+1]
+					break
+				}
+			}
+		case yaml.SequenceNode:
+			if i, err := strconv.Atoi(key); err == nil && i >= 0 && i < len(node.Content) {
+				line, next = node.Content[i].Line, node.Content[i]
+			}
+		}
+		if next == nil {
+			return line
+		}
+		node = next
+	}
+	return line
+}
+
+// lintFlagKeys reports unknown keys of the flag mappings of a challenge,
+// which are decoded by challengeFlags.UnmarshalYAML and thereby not checked
+// by the strict decoder.
+func lintFlagKeys(file string, doc *yaml.Node) []lintIssue {
+	var issues []lintIssue
+
+	known := make(map[string]bool)
+	t := reflect.TypeOf(challengeFlag{})
+	for i := 0; i < t.NumField(); i++ {
+		if tag := t.Field(i).Tag.Get("yaml"); tag != "" {
+			known[tag] = true
+		}
+	}
+
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil
+	}
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "flag" {
+			continue
+		}
+		nodes := []*yaml.Node{root.Content[i+1]}
+		if nodes[0].Kind == yaml.SequenceNode {
+			nodes = nodes[0].Content
+		}
+		for _, n := range nodes {
+			if n.Kind != yaml.MappingNode {
+				continue
+			}
+			for j := 0; j+1 < len(n.Content); j += 2 {
+				if key := n.Content[j]; !known[key.Value] {
+					issues = append(issues, lintIssue{File: file, Line: key.Line,
+						Message: fmt.Sprintf("unknown key \"%s\"", key.Value)})
+				}
+			}
+		}
+	}
+	return issues
+}
+
+// lintCTF checks the CTF at path and returns all found issues.
+func lintCTF(path string) []lintIssue {
+	var issues []lintIssue
+
+	var conf configuration
+	confFile := filepath.Join(path, "ctf.yml")
+	confDoc, confIssues := lintYAML(confFile, &conf)
+	issues = append(issues, confIssues...)
+	if confDoc != nil && conf.ServiceRuntime != "" &&
+		conf.ServiceRuntime != runtimeDocker && conf.ServiceRuntime != runtimeFake {
+		issues = append(issues, lintIssue{File: confFile, Line: keyLine(confDoc, "serviceRuntime"),
+			Message: fmt.Sprintf("unknown service runtime \"%s\"", conf.ServiceRuntime)})
+	}
+	if p := conf.InstancePorts; confDoc != nil && p != nil && (len(p) != 2 || p[0] < 1 || p[0] > p[1] || p[1] > 65535) {
+		issues = append(issues, lintIssue{File: confFile, Line: keyLine(confDoc, "instancePorts"),
+			Message: "instance ports must be a range like [40000, 40999]"})
+	}
+	if confDoc != nil && (conf.TLSCert == "") != (conf.TLSKey == "") {
+		key := "tlsCert"
+		if conf.TLSCert == "" {
+			key = "tlsKey"
+		}
+		issues = append(issues, lintIssue{File: confFile, Line: keyLine(confDoc, key),
+			Message: "tlsCert and tlsKey have to be set together"})
+	}
+	for _, proxy := range conf.TrustedProxies {
+		if _, err := parseProxy(proxy); err != nil {
+			issues = append(issues, lintIssue{File: confFile, Line: keyLine(confDoc, "trustedProxies"),
+				Message: err.Error()})
+		}
+	}
+
+	challengePath := filepath.Join(path, "challenges")
+	items, err := os.ReadDir(challengePath)
+	if err != nil {
+		return append(issues, lintIssue{File: challengePath, Message: err.Error()})
+	}
+
+	ids := make(map[string]bool)
+	for _, item := range items {
+		if item.IsDir() {
+			ids[item.Name()] = true
+		}
+	}
+
+	for _, item := range items {
+		if item.IsDir() {
+			issues = append(issues, lintChallenge(filepath.Join(challengePath, item.Name()), ids)...)
+		}
+	}
+
+	return issues
+}
+
+func lintChallenge(path string, ids map[string]bool) []lintIssue {
+	file := filepath.Join(path, "challenge.yml")
+
+	var cha challenge
+	doc, issues := lintYAML(file, &cha)
+	if doc == nil || len(doc.Content) == 0 {
+		return issues
+	}
+	issues = append(issues, lintFlagKeys(file, doc)...)
+	issue := func(path, format string, a ...interface{}) {
+		issues = append(issues, lintIssue{File: file, Line: keyLine(doc, path), Message: fmt.Sprintf(format, a...)})
+	}
+
+	if cha.Title == "" {
+		issue("name", "challenge has no name")
+	}
+	if cha.Category == "" {
+		issue("category", "challenge has no category")
+	}
+	if len(cha.Flag) == 0 {
+		issue("flag", "challenge has no flag")
+	}
+	for i, f := range cha.Flag {
+		if f.Type != flagTypeDynamic && f.Value == "" {
+			issue(fmt.Sprintf("flag.%d", i), "challenge has an empty flag")
+		}
+	}
+
+	if cha.dynamic() {
+		s := cha.Scoring
+		initial := s.Initial
+		if initial == 0 {
+			initial = cha.Points
+		}
+		if initial <= 0 {
+			issue("scoring.initial", "initial value must be positive, got %d", initial)
+		}
+		if s.Minimum < 0 || s.Minimum > initial {
+			issue("scoring.minimum", "minimum value must be between 0 and the initial value, got %d", s.Minimum)
+		}
+	} else {
+		if cha.Scoring.Decay < 0 {
+			issue("scoring.decay", "decay must be positive, got %d", cha.Scoring.Decay)
+		}
+		if cha.Points <= 0 {
+			issue("value", "value must be positive, got %d", cha.Points)
+		}
+	}
+
+	seen := make(map[string]int)
+	for i, hint := range cha.Hints {
+		if hint.Cost < 0 {
+			issue(fmt.Sprintf("hints.%d.cost", i), "hint %d has a negative cost", i)
+		}
+		if hint.Text == "" {
+			issue(fmt.Sprintf("hints.%d", i), "hint %d has no description", i)
+		}
+		if first, ok := seen[hint.Text]; ok {
+			issue(fmt.Sprintf("hints.%d.description", i), "hint %d has the same description as hint %d", i, first)
+		} else {
+			seen[hint.Text] = i
+		}
+	}
+
+	for _, required := range cha.Requires {
+		if !ids[required] {
+			issue("requires", "required challenge \"%s\" does not exist", required)
+		}
+		if required == filepath.Base(path) {
+			issue("requires", "challenge requires itself")
+		}
+	}
+
+	if cha.Service.Port != 0 {
+		if cha.Service.Port < 0 || cha.Service.Port > 65535 {
+			issue("service.port", "service port %d is out of range", cha.Service.Port)
+		}
+		if _, err := os.Stat(filepath.Join(path, "Dockerfile")); err != nil {
+			issue("service", "service has a port but there is no Dockerfile")
+		}
+	}
+	if cha.Service.Instanced && cha.Service.Port == 0 {
+		issue("service.instanced", "instanced service has no port")
+	}
+	if cha.Service.TTL < 0 {
+		issue("service.ttl", "instance ttl must be positive, got %d", cha.Service.TTL)
+	}
+	if health := cha.Service.Health; health.Type != "" {
+		switch health.Type {
+		case healthTypeTCP, healthTypeHTTP:
+		case healthTypeExpect:
+			if health.Expect == "" {
+				issue("service.health.expect", "expect health check has no expect string")
+			}
+		default:
+			issue("service.health.type", "unknown health check type \"%s\"", health.Type)
+		}
+		if cha.Service.Port == 0 {
+			issue("service.health", "health check but the service has no port")
+		}
+		if cha.Service.Instanced {
+			issue("service.health", "health checks do not run for instanced services")
+		}
+		if health.Interval < 0 {
+			issue("service.health.interval", "health check interval must be positive, got %d", health.Interval)
+		}
+	}
+
+	return issues
+}
+
+// runLint implements the lint command and returns the exit code.
+func runLint(args []string) int {
+	var ctfLocation string
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	flags.StringVar(&ctfLocation, "l", "/ctf", "lint the ctf in this directory")
+	_ = flags.Parse(args)
+
+	issues := lintCTF(ctfLocation)
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].File != issues[j].File {
+			return issues[i].File < issues[j].File
+		}
+		return issues[i].Line < issues[j].Line
+	})
+	for _, issue := range issues {
+		fmt.Println(issue)
+	}
+
+	if len(issues) > 0 {
+		fmt.Printf("%d issues found\n", len(issues))
+		return 1
+	}
+	fmt.Println("no issues found")
+	return 0
+}
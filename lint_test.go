@@ -0,0 +1,84 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLintChallengeLines(t *testing.T) {
+	ctf := newTestCTF(t, "title: test\nsessionTimeout: 600\n", map[string]string{
+		"web": `name: web
+category: web
+value: 100
+flag:
+  - CTF{web}
+  - value: ctf{web}
+    tpye: case-insensitive
+service:
+  port: 1337
+  ttl: -1
+  health:
+    type: tcpp
+    interval: -5
+hints:
+  - description: first
+  - description: second
+    cost: -10
+`,
+	})
+
+	file := filepath.Join(ctf.Location, "challenges", "web", "challenge.yml")
+	want := map[lintIssue]bool{
+		{File: file, Line: 7, Message: `unknown key "tpye"`}:                              true,
+		{File: file, Line: 10, Message: "instance ttl must be positive, got -1"}:          true,
+		{File: file, Line: 12, Message: `unknown health check type "tcpp"`}:               true,
+		{File: file, Line: 13, Message: "health check interval must be positive, got -5"}: true,
+		{File: file, Line: 17, Message: "hint 1 has a negative cost"}:                     true,
+	}
+
+	issues := lintChallenge(filepath.Dir(file), map[string]bool{"web": true})
+	for _, issue := range issues {
+		if !want[issue] {
+			t.Errorf("unexpected issue %s", issue)
+		}
+		delete(want, issue)
+	}
+	for issue := range want {
+		t.Errorf("missing issue %s", issue)
+	}
+}
+
+func TestKeyLine(t *testing.T) {
+	ctf := newTestCTF(t, "title: test\nsessionTimeout: 600\n", map[string]string{
+		"web": "name: web\nservice:\n  port: 1337\n  health:\n    type: tcp\n",
+	})
+	doc, _ := lintYAML(filepath.Join(ctf.Location, "challenges", "web", "challenge.yml"), &challenge{})
+
+	for path, want := range map[string]int{
+		"name":                  1,
+		"service.health.type":   5,
+		"service.health.expect": 4,
+		"service.ttl":           2,
+		"hints.0":               0,
+	} {
+		if got := keyLine(doc, path); got != want {
+			t.Errorf("keyLine(%q) = %d, want %d", path, got, want)
+		}
+	}
+}
+
+func TestLintEmptyChallenge(t *testing.T) {
+	ctf := newTestCTF(t, "title: test\nsessionTimeout: 600\n", map[string]string{
+		"empty":   "",
+		"comment": "# name: comment\n",
+	})
+
+	for _, id := range []string{"empty", "comment"} {
+		file := filepath.Join(ctf.Location, "challenges", id, "challenge.yml")
+		issues := lintChallenge(filepath.Dir(file), map[string]bool{id: true})
+		want := lintIssue{File: file, Message: "file is empty"}
+		if len(issues) != 1 || issues[0] != want {
+			t.Errorf("%s: issues %v, want [%s]", id, issues, want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"strings"
+)
+
+// passwordParams are the argon2id parameters used for new password hashes.
+// Hashes created with other parameters are still accepted and upgraded on
+// the next successful login.
+type passwordParams struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+var defaultPasswordParams = passwordParams{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+const passwordSchemeArgon2id = "argon2id"
+
+// generatePassword returns a random password for users created by the CLI.
+func generatePassword() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashPassword returns a PHC formatted argon2id hash of password, e.g.
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func hashPassword(password string) (string, error) {
+	p := defaultPasswordParams
+
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		passwordSchemeArgon2id, argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// verifyPassword checks password against a stored hash. Besides PHC
+// formatted argon2id hashes it accepts the legacy sha256(password+salt)
+// format, which is recognised by a non-empty legacySalt. needsRehash reports
+// whether the stored hash should be replaced by a fresh hashPassword result.
+func verifyPassword(password, stored, legacySalt string) (ok bool, needsRehash bool, err error) {
+	if legacySalt != "" {
+		hash := sha256.Sum256([]byte(password + legacySalt))
+		ok = subtle.ConstantTimeCompare([]byte(stored), hash[:]) == 1
+		return ok, true, nil
+	}
+
+	p, salt, key, err := decodePasswordHash(stored)
+	if err != nil {
+		return false, false, err
+	}
+
+	calculated := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	if subtle.ConstantTimeCompare(key, calculated) != 1 {
+		return false, false, nil
+	}
+
+	d := defaultPasswordParams
+	needsRehash = p.Memory != d.Memory || p.Iterations != d.Iterations || p.Parallelism != d.Parallelism ||
+		p.SaltLength != d.SaltLength || p.KeyLength != d.KeyLength
+	return true, needsRehash, nil
+}
+
+func decodePasswordHash(stored string) (passwordParams, []byte, []byte, error) {
+	var p passwordParams
+
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, fmt.Errorf("invalid password hash format")
+	}
+	if parts[1] != passwordSchemeArgon2id {
+		return p, nil, nil, fmt.Errorf("unsupported password hash scheme \"%s\"", parts[1])
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return p, nil, nil, err
+	}
+	if version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, err
+	}
+	// argon2 panics without iterations or threads, and a stored hash must not
+	// make a login allocate more than 4 GiB
+	if p.Iterations < 1 || p.Parallelism < 1 || p.Memory > 4*1024*1024 {
+		return p, nil, nil, fmt.Errorf("invalid argon2 parameters")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	p.SaltLength = uint32(len(salt))
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	p.KeyLength = uint32(len(key))
+	// an empty key would match every password
+	if p.SaltLength == 0 || p.KeyLength == 0 {
+		return p, nil, nil, fmt.Errorf("invalid password hash format")
+	}
+
+	return p, salt, key, nil
+}
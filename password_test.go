@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"testing"
+)
+
+func TestPasswordRoundTrip(t *testing.T) {
+	hash, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, needsRehash, err := verifyPassword("correct horse", hash, "")
+	if err != nil || !ok || needsRehash {
+		t.Fatalf("verify right password: ok %v, needsRehash %v, err %v", ok, needsRehash, err)
+	}
+	ok, _, err = verifyPassword("wrong horse", hash, "")
+	if err != nil || ok {
+		t.Fatalf("verify wrong password: ok %v, err %v", ok, err)
+	}
+
+	other, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == hash {
+		t.Fatal("two hashes of the same password share their salt")
+	}
+}
+
+func TestPasswordLegacy(t *testing.T) {
+	sum := sha256.Sum256([]byte("correct horse" + "salt"))
+	stored := string(sum[:])
+
+	ok, needsRehash, err := verifyPassword("correct horse", stored, "salt")
+	if err != nil || !ok || !needsRehash {
+		t.Fatalf("verify legacy hash: ok %v, needsRehash %v, err %v", ok, needsRehash, err)
+	}
+	ok, _, err = verifyPassword("wrong horse", stored, "salt")
+	if err != nil || ok {
+		t.Fatalf("verify wrong legacy password: ok %v, err %v", ok, err)
+	}
+}
+
+func TestPasswordOtherParams(t *testing.T) {
+	salt := []byte("saltsaltsaltsalt")
+	key := argon2.IDKey([]byte("password"), salt, 1, 1024, 1, 32)
+	stored := fmt.Sprintf("$argon2id$v=%d$m=1024,t=1,p=1$%s$%s", argon2.Version,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+
+	ok, needsRehash, err := verifyPassword("password", stored, "")
+	if err != nil || !ok || !needsRehash {
+		t.Fatalf("verify hash with other parameters: ok %v, needsRehash %v, err %v", ok, needsRehash, err)
+	}
+}
+
+func TestPasswordMalformed(t *testing.T) {
+	for _, stored := range []string{
+		"",
+		"plain",
+		"$argon2id$v=19$m=65536,t=3,p=2$c2FsdA",
+		"$bcrypt$v=19$m=65536,t=3,p=2$c2FsdA$a2V5",
+		"$argon2id$v=16$m=65536,t=3,p=2$c2FsdA$a2V5",
+		"$argon2id$v=x$m=65536,t=3,p=2$c2FsdA$a2V5",
+		"$argon2id$v=19$m=65536$c2FsdA$a2V5",
+		"$argon2id$v=19$m=65536,t=0,p=2$c2FsdA$a2V5",
+		"$argon2id$v=19$m=65536,t=3,p=0$c2FsdA$a2V5",
+		"$argon2id$v=19$m=65536,t=3,p=2$!!!$a2V5",
+		"$argon2id$v=19$m=65536,t=3,p=2$c2FsdA$!!!",
+		"$argon2id$v=19$m=65536,t=3,p=2$c2FsdA$",
+		"$argon2id$v=19$m=65536,t=3,p=2$$a2V5",
+	} {
+		ok, _, err := verifyPassword("password", stored, "")
+		if err == nil || ok {
+			t.Errorf("verify %q: ok %v, err %v, want an error", stored, ok, err)
+		}
+	}
+}
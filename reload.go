@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// reloadInterval is the interval in which the CTF directory is checked for
+// changes.
+const reloadInterval = 2 * time.Second
+
+// reload re-reads ctf.yml, index.md and all challenges and swaps them in once
+// all running requests are done. If ctf.yml cannot be read, the current
+// configuration is kept. Challenges that cannot be read keep their previous
+// version.
+func (ctf *ctf) reload() error {
+	conf, confErr := readConfiguration(ctf.Location)
+	if confErr != nil {
+		fmt.Printf("[ERROR] could not reload configuration: %s!\n", confErr)
+	}
+
+	challenges, errs := readChallenges(ctf.Location)
+
+	ctf.lock.Lock()
+	defer ctf.lock.Unlock()
+
+	for id, err := range errs {
+		fmt.Printf("[ERROR] could not reload challenge \"%s\": %s!\n", id, err)
+		if old, ok := ctf.Challenges[id]; ok {
+			challenges[id] = old
+		}
+	}
+	ctf.Challenges = challenges
+
+	if confErr == nil {
+		if conf.FlagSecret == "" {
+			conf.FlagSecret = ctf.Configuration.FlagSecret
+		}
+		ctf.Configuration = conf
+	}
+
+	fmt.Printf("[INFO] reloaded CTF from \"%s\" (%d challenges)\n", ctf.Location, len(challenges))
+	if confErr != nil {
+		return confErr
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of the challenges could not be reloaded", len(errs))
+	}
+	return nil
+}
+
+// fingerprint summarizes names, sizes and modification times of all files
+// that make up the CTF definition.
+func (ctf *ctf) fingerprint() string {
+	var b strings.Builder
+
+	for _, name := range []string{"ctf.yml", "index.md"} {
+		if fi, err := os.Stat(filepath.Join(ctf.Location, name)); err == nil {
+			fmt.Fprintf(&b, "%s:%d:%d;", name, fi.Size(), fi.ModTime().UnixNano())
+		}
+	}
+
+	_ = filepath.WalkDir(filepath.Join(ctf.Location, "challenges"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		fmt.Fprintf(&b, "%s:%d:%d;", path, fi.Size(), fi.ModTime().UnixNano())
+		return nil
+	})
+
+	return b.String()
+}
+
+// watch reloads the CTF whenever its files change or SIGHUP is received,
+// until ctx is done.
+func (ctf *ctf) watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+	last := ctf.fingerprint()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			_ = ctf.reload()
+			last = ctf.fingerprint()
+		case <-ticker.C:
+			current := ctf.fingerprint()
+			if current != last {
+				last = current
+				_ = ctf.reload()
+			}
+		}
+	}
+}
+
+// lockMiddleware makes requests wait for running reloads and reloads wait for
+// running requests.
+func (ctf *ctf) lockMiddleware(c *fiber.Ctx) error {
+	ctf.lock.RLock()
+	defer ctf.lock.RUnlock()
+	return c.Next()
+}
@@ -33,6 +33,10 @@ func rGetChallenges(c *fiber.Ctx, ctf *ctf) error {
 		return c.Redirect("/")
 	}
 
+	if !ctf.Configuration.started() {
+		return renderWithSession(c, *ctf, "countdown")
+	}
+
 	solvedChallenges, err := ctf.solvedChallenges(c)
 	if err != nil {
 		solvedChallenges = []string{}
@@ -43,9 +47,28 @@ func rGetChallenges(c *fiber.Ctx, ctf *ctf) error {
 		return handleError(c, err)
 	}
 
+	live := ctf.sessionIsAdmin(c)
+	challenges, err := ctf.currentChallenges(live)
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	firstBloods, err := ctf.firstBloods(live)
+	if err != nil {
+		return handleError(c, err)
+	}
+	solveCounts, err := ctf.solveCounts(live)
+	if err != nil {
+		return handleError(c, err)
+	}
+
 	return renderWithSession(c, *ctf, "challenges", fiber.Map{
+		"Challenges":       challenges,
+		"LockedChallenges": ctf.lockedChallenges(c),
 		"SolvedChallenges": solvedChallenges,
 		"Categories":       categories,
+		"FirstBloods":      firstBloods,
+		"SolveCounts":      solveCounts,
 	})
 }
 
@@ -56,10 +79,40 @@ func rGetChallenge(c *fiber.Ctx, ctf *ctf) error {
 		return c.Redirect("/")
 	}
 
+	if !ctf.Configuration.started() {
+		ctf.addToast(c, "CTF not started",
+			"The CTF has not started yet.")
+		return c.Redirect("/challenges")
+	}
+	if ctf.isLocked(c, c.Params("challengePath")) {
+		ctf.addToast(c, "Challenge locked",
+			"You need to solve the required challenges first.")
+		return c.Redirect("/challenges")
+	}
+
+	challenges, err := ctf.currentChallenges(ctf.sessionIsAdmin(c))
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	solvers, err := ctf.solvers(c.Params("challengePath"), ctf.sessionIsAdmin(c))
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	instance, running := ctf.instance(c, c.Params("challengePath"))
+	health, checked := ctf.Health.status(c.Params("challengePath"))
+
 	return renderWithSession(c, *ctf, "challenge", fiber.Map{
-		"Challenge": ctf.Challenges[c.Params("challengePath")],
-		"Hints":     ctf.getHints(c, c.Params("challengePath")),
-		"Solved":    ctf.isSolved(c, c.Params("challengePath")),
+		"Challenge":       challenges[c.Params("challengePath")],
+		"Hints":           ctf.getHints(c, c.Params("challengePath")),
+		"Solved":          ctf.isSolved(c, c.Params("challengePath")),
+		"Solvers":         solvers,
+		"Instance":        instance,
+		"InstanceRunning": running,
+		"Instances":       ctf.Services != nil,
+		"Health":          health.Last(),
+		"HealthChecked":   checked,
 	})
 }
 
@@ -77,6 +130,12 @@ func rPostChallenge(c *fiber.Ctx, ctf *ctf) error {
 		return err
 	}
 
+	if err := ctf.running(); err != nil {
+		ctf.addToast(c, "Submission closed",
+			fmt.Sprintf("Flags cannot be submitted: %s.", err))
+		return c.Redirect(fmt.Sprintf("/challenges/%s", c.Params("challengePath")))
+	}
+
 	challenge := ctf.Challenges[c.Params("challengePath")]
 
 	if ctf.coolDownActive(c) {
@@ -107,8 +166,26 @@ func rGetChallengeFile(c *fiber.Ctx, ctf *ctf) error {
 			"You need to log in to download files.")
 		return c.Redirect("/")
 	}
+	if !ctf.Configuration.started() {
+		ctf.addToast(c, "CTF not started",
+			"The CTF has not started yet.")
+		return c.Redirect("/challenges")
+	}
+	if ctf.isLocked(c, c.Params("challengePath")) {
+		ctf.addToast(c, "Challenge locked",
+			"You need to solve the required challenges first.")
+		return c.Redirect("/challenges")
+	}
 	challenge := ctf.Challenges[c.Params("challengePath")]
 	file := challenge.Files[c.Params("fileID")]
+	if file.Template {
+		content, err := ctf.renderChallengeFile(c, c.Params("challengePath"), file)
+		if err != nil {
+			return handleError(c, err)
+		}
+		c.Attachment(file.Filename)
+		return c.Send(content)
+	}
 	return c.Download(file.Location)
 }
 
@@ -140,15 +217,92 @@ func rGetDeleteToast(c *fiber.Ctx, ctf *ctf) error {
 }
 
 func rGetScore(c *fiber.Ctx, ctf *ctf) error {
-	scores, err := ctf.scores()
+	// admins always see the live scoreboard
+	live := ctf.sessionIsAdmin(c)
+	scores, err := ctf.scores(live)
 	if err != nil {
 		return handleError(c, err)
 	}
 	return renderWithSession(c, *ctf, "score", fiber.Map{
 		"Scores": scores,
+		"Frozen": ctf.Configuration.frozen(),
+		"Live":   live,
 	})
 }
 
+func rGetTeam(c *fiber.Ctx, ctf *ctf) error {
+	if !ctf.Configuration.Teams {
+		return c.Redirect("/")
+	}
+	u, err := ctf.ensureLoggedIn(c)
+	if err != nil {
+		ctf.addToast(c, "Login needed",
+			"You need to log in to manage your team.")
+		return c.Redirect("/")
+	}
+
+	t, err := u.team()
+	return renderWithSession(c, *ctf, "team", fiber.Map{
+		"HasTeam": err == nil,
+		"Team":    t,
+	})
+}
+
+func rPostTeamCreate(c *fiber.Ctx, ctf *ctf) error {
+	payload := struct {
+		Name string `form:"name"`
+	}{}
+
+	if err := c.BodyParser(&payload); err != nil {
+		return err
+	}
+
+	err := ctf.createTeam(c, payload.Name)
+	switch err {
+	case nil:
+		ctf.addToast(c, "Team created",
+			fmt.Sprintf("You created the team \"%s\".", payload.Name))
+	default:
+		ctf.addToast(c, "Team creation failed",
+			fmt.Sprintf("The team could not be created: %s.", err))
+	}
+	return c.Redirect("/team")
+}
+
+func rPostTeamJoin(c *fiber.Ctx, ctf *ctf) error {
+	payload := struct {
+		Invite string `form:"invite"`
+	}{}
+
+	if err := c.BodyParser(&payload); err != nil {
+		return err
+	}
+
+	err := ctf.joinTeam(c, payload.Invite)
+	switch err {
+	case nil:
+		ctf.addToast(c, "Team joined",
+			"You joined the team.")
+	default:
+		ctf.addToast(c, "Joining failed",
+			fmt.Sprintf("The team could not be joined: %s.", err))
+	}
+	return c.Redirect("/team")
+}
+
+func rPostTeamLeave(c *fiber.Ctx, ctf *ctf) error {
+	err := ctf.leaveTeam(c)
+	switch err {
+	case nil:
+		ctf.addToast(c, "Team left",
+			"You left your team.")
+	default:
+		ctf.addToast(c, "Leaving failed",
+			fmt.Sprintf("The team could not be left: %s.", err))
+	}
+	return c.Redirect("/team")
+}
+
 func rPostLogin(c *fiber.Ctx, ctf *ctf) error {
 	payload := struct {
 		Username string `form:"username"`
@@ -200,6 +354,25 @@ func rPostSignup(c *fiber.Ctx, ctf *ctf) error {
 }
 
 func addRoutes(app *fiber.App, ctf *ctf) {
+	// reload the CTF, this cannot hold the lock of lockMiddleware
+	app.Post("/admin/reload", func(c *fiber.Ctx) error {
+		return rPostAdminReload(c, ctf)
+	})
+
+	// stream live updates, this cannot hold the lock of lockMiddleware either
+	app.Get("/events", func(c *fiber.Ctx) error {
+		return rGetEvents(c, ctf)
+	})
+
+	// start, extend or stop a private service instance, starting containers
+	// takes too long to hold the lock of lockMiddleware
+	app.Post("/challenges/:challengePath/instance/:action", func(c *fiber.Ctx) error {
+		return rPostChallengeInstance(c, ctf)
+	})
+
+	app.Use(ctf.lockMiddleware)
+	app.Use(ctf.announcementMiddleware)
+
 	app.Get("/", func(c *fiber.Ctx) error {
 
 		return renderWithSession(c, *ctf, "index")
@@ -214,7 +387,7 @@ func addRoutes(app *fiber.App, ctf *ctf) {
 	})
 
 	// try to solve a challenge
-	app.Post("/challenges/:challengePath", func(c *fiber.Ctx) error {
+	app.Post("/challenges/:challengePath", ctf.tokenMiddleware, func(c *fiber.Ctx) error {
 		return rPostChallenge(c, ctf)
 	})
 
@@ -238,6 +411,51 @@ func addRoutes(app *fiber.App, ctf *ctf) {
 		return rGetScore(c, ctf)
 	})
 
+	// get the score graph of the top players
+	app.Get("/score/graph.json", func(c *fiber.Ctx) error {
+		return rGetScoreGraph(c, ctf)
+	})
+
+	// list announcements
+	app.Get("/announcements", func(c *fiber.Ctx) error {
+		return rGetAnnouncements(c, ctf)
+	})
+
+	// get scoreboard in the CTFtime feed format
+	app.Get("/ctftime.json", func(c *fiber.Ctx) error {
+		return rGetCTFtime(c, ctf)
+	})
+
+	// show or manage own team
+	app.Get("/team", func(c *fiber.Ctx) error {
+		return rGetTeam(c, ctf)
+	})
+
+	app.Post("/team/create", func(c *fiber.Ctx) error {
+		return rPostTeamCreate(c, ctf)
+	})
+
+	app.Post("/team/join", func(c *fiber.Ctx) error {
+		return rPostTeamJoin(c, ctf)
+	})
+
+	app.Post("/team/leave", func(c *fiber.Ctx) error {
+		return rPostTeamLeave(c, ctf)
+	})
+
+	// show profile and manage API tokens
+	app.Get("/profile", func(c *fiber.Ctx) error {
+		return rGetProfile(c, ctf, "")
+	})
+
+	app.Post("/profile/tokens", func(c *fiber.Ctx) error {
+		return rPostProfileToken(c, ctf)
+	})
+
+	app.Post("/profile/tokens/:tokenID/revoke", func(c *fiber.Ctx) error {
+		return rPostProfileTokenRevoke(c, ctf)
+	})
+
 	// login user
 	app.Post("/login", func(c *fiber.Ctx) error {
 		return rPostLogin(c, ctf)
@@ -252,4 +470,7 @@ func addRoutes(app *fiber.App, ctf *ctf) {
 	app.Post("/signup", func(c *fiber.Ctx) error {
 		return rPostSignup(c, ctf)
 	})
+
+	addAdminRoutes(app, ctf)
+	addAPIRoutes(app, ctf)
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	runtimeDocker = "docker"
+	runtimeFake   = "fake"
+)
+
+// containerSpec describes a container of a challenge service. Port is the
+// port the service listens on inside the container, HostPort the port it is
+// published on.
+type containerSpec struct {
+	Name     string
+	Image    string
+	Port     int
+	HostPort int
+	Env      map[string]string
+}
+
+const (
+	containerRunning = "running"
+	containerExited  = "exited"
+	containerMissing = "missing"
+)
+
+// serviceRuntime builds and runs the containers of challenge services.
+type serviceRuntime interface {
+	// build builds the image from the Dockerfile in dir.
+	build(ctx context.Context, image, dir string) error
+	// start creates and starts a container, replacing an existing one with
+	// the same name.
+	start(ctx context.Context, spec containerSpec) error
+	// stop stops and removes a container. Missing containers are ignored.
+	stop(ctx context.Context, name string) error
+	// state returns one of containerRunning, containerExited or
+	// containerMissing, or the state reported by the runtime.
+	state(ctx context.Context, name string) (string, error)
+}
+
+// newServiceRuntime returns the runtime configured by serviceRuntime in
+// ctf.yml. The docker runtime connects to DOCKER_HOST or the default socket.
+func newServiceRuntime(kind string) (serviceRuntime, error) {
+	switch kind {
+	case runtimeDocker:
+		return newDockerRuntime(os.Getenv("DOCKER_HOST"))
+	case runtimeFake:
+		return newFakeRuntime(), nil
+	default:
+		return nil, fmt.Errorf("unknown service runtime \"%s\"", kind)
+	}
+}
+
+// serviceName returns the name of the container or image of a challenge.
+// Docker only allows lowercase image names.
+func serviceName(challengeID string) string {
+	return "ctfengine-" + strings.ToLower(challengeID)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// dockerAPIVersion is the Docker Engine API version requested, supported by
+// Docker 20.10 and later.
+const dockerAPIVersion = "v1.41"
+
+// dockerRuntime talks to the Docker Engine API directly, so no Docker client
+// library is needed.
+type dockerRuntime struct {
+	client *http.Client
+	base   string
+}
+
+// newDockerRuntime connects to host, which is given like DOCKER_HOST, e.g.
+// unix:///var/run/docker.sock or tcp://127.0.0.1:2375.
+func newDockerRuntime(host string) (*dockerRuntime, error) {
+	if host == "" {
+		host = "unix:///var/run/docker.sock"
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		return &dockerRuntime{
+			client: &http.Client{Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+				},
+			}},
+			base: "http://docker/" + dockerAPIVersion,
+		}, nil
+	case "tcp", "http":
+		return &dockerRuntime{
+			client: &http.Client{},
+			base:   "http://" + u.Host + "/" + dockerAPIVersion,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported docker host \"%s\"", host)
+	}
+}
+
+// request sends a request to the Docker API and returns the response if its
+// status is one of ok.
+func (d *dockerRuntime) request(ctx context.Context, method, path string, body io.Reader, contentType string,
+	ok ...int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, d.base+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range ok {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	message := struct {
+		Message string `json:"message"`
+	}{}
+	if json.NewDecoder(resp.Body).Decode(&message) != nil || message.Message == "" {
+		message.Message = resp.Status
+	}
+	return nil, fmt.Errorf("docker: %s", message.Message)
+}
+
+func (d *dockerRuntime) build(ctx context.Context, image, dir string) error {
+	buildContext, err := tarDirectory(dir)
+	if err != nil {
+		return err
+	}
+
+	resp, err := d.request(ctx, http.MethodPost, "/build?rm=1&t="+url.QueryEscape(image),
+		buildContext, "application/x-tar", http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	// the build output is streamed as JSON messages, errors are reported
+	// within them
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		message := struct {
+			Error string `json:"error"`
+		}{}
+		if json.Unmarshal(scanner.Bytes(), &message) == nil && message.Error != "" {
+			return fmt.Errorf("docker build: %s", strings.TrimSpace(message.Error))
+		}
+	}
+	return scanner.Err()
+}
+
+func (d *dockerRuntime) start(ctx context.Context, spec containerSpec) error {
+	if err := d.stop(ctx, spec.Name); err != nil {
+		return err
+	}
+
+	var env []string
+	for key, value := range spec.Env {
+		env = append(env, key+"="+value)
+	}
+	port := fmt.Sprintf("%d/tcp", spec.Port)
+	config := map[string]interface{}{
+		"Image":        spec.Image,
+		"Env":          env,
+		"ExposedPorts": map[string]interface{}{port: struct{}{}},
+		"HostConfig": map[string]interface{}{
+			"PortBindings": map[string]interface{}{
+				port: []map[string]string{{"HostPort": strconv.Itoa(spec.HostPort)}},
+			},
+		},
+	}
+	body, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	resp, err := d.request(ctx, http.MethodPost, "/containers/create?name="+url.QueryEscape(spec.Name),
+		bytes.NewReader(body), "application/json", http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+
+	resp, err = d.request(ctx, http.MethodPost, "/containers/"+url.PathEscape(spec.Name)+"/start",
+		nil, "", http.StatusNoContent, http.StatusNotModified)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (d *dockerRuntime) stop(ctx context.Context, name string) error {
+	resp, err := d.request(ctx, http.MethodDelete, "/containers/"+url.PathEscape(name)+"?force=1",
+		nil, "", http.StatusNoContent, http.StatusNotFound)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (d *dockerRuntime) state(ctx context.Context, name string) (string, error) {
+	resp, err := d.request(ctx, http.MethodGet, "/containers/"+url.PathEscape(name)+"/json",
+		nil, "", http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode == http.StatusNotFound {
+		return containerMissing, nil
+	}
+
+	info := struct {
+		State struct {
+			Status string `json:"Status"`
+		} `json:"State"`
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return "", err
+	}
+	return info.State.Status, nil
+}
+
+// tarDirectory packs dir as build context.
+func tarDirectory(dir string) (io.Reader, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() && !fi.IsDir() {
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if err = tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tw.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// fakeRuntime is an in-process serviceRuntime that only records images and
+// containers. It is used by the service tests and for trying out a CTF
+// without Docker.
+type fakeRuntime struct {
+	mu         sync.Mutex
+	images     map[string]bool
+	containers map[string]fakeContainer
+}
+
+type fakeContainer struct {
+	spec  containerSpec
+	state string
+}
+
+func newFakeRuntime() *fakeRuntime {
+	return &fakeRuntime{
+		images:     make(map[string]bool),
+		containers: make(map[string]fakeContainer),
+	}
+}
+
+func (f *fakeRuntime) build(_ context.Context, image, dir string) error {
+	if _, err := os.Stat(filepath.Join(dir, "Dockerfile")); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.images[image] = true
+	return nil
+}
+
+func (f *fakeRuntime) start(_ context.Context, spec containerSpec) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.images[spec.Image] {
+		return fmt.Errorf("image \"%s\" does not exist", spec.Image)
+	}
+	for name, c := range f.containers {
+		if name != spec.Name && c.state == containerRunning && c.spec.HostPort == spec.HostPort {
+			return fmt.Errorf("port %d is already allocated", spec.HostPort)
+		}
+	}
+	f.containers[spec.Name] = fakeContainer{spec: spec, state: containerRunning}
+	return nil
+}
+
+func (f *fakeRuntime) stop(_ context.Context, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.containers, name)
+	return nil
+}
+
+func (f *fakeRuntime) state(_ context.Context, name string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.containers[name]
+	if !ok {
+		return containerMissing, nil
+	}
+	return c.state, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"time"
+)
+
+// scoreGraphDefaultTop is the number of players shown in the score graph if
+// the request does not ask for a different number.
+const scoreGraphDefaultTop = 10
+
+type scoreGraphPoint struct {
+	Time   time.Time `json:"time"`
+	Points int       `json:"points"`
+}
+
+type scoreGraphSeries struct {
+	Name   string            `json:"name"`
+	Points []scoreGraphPoint `json:"points"`
+}
+
+// scoreGraph returns the cumulative points over time of the top players of
+// the scoreboard. Every series starts with zero points at the start of the
+// CTF, or at the first solve if no start is configured, and ends with the
+// current score.
+func (ctf *ctf) scoreGraph(live bool, top int) ([]scoreGraphSeries, error) {
+	series := []scoreGraphSeries{}
+
+	scores, err := ctf.scores(live)
+	if err != nil {
+		return series, err
+	}
+	if len(scores) > top {
+		scores = scores[:top]
+	}
+
+	until := ctf.scoreboardUntil(live)
+	values, err := ctf.values(until)
+	if err != nil {
+		return series, err
+	}
+	solves, err := dbGetSolves(ctf.Storage, values, until, ctf.Configuration.Teams)
+	if err != nil {
+		return series, err
+	}
+
+	end := time.Now()
+	if !until.IsZero() && until.Before(end) {
+		end = until
+	}
+	if ctf.Configuration.ended() {
+		end = ctf.Configuration.End
+	}
+	start := ctf.Configuration.Start
+	if start.IsZero() {
+		start = end
+		if len(solves) > 0 {
+			start = solves[0][3].(time.Time)
+		}
+	}
+
+	bySeries := make(map[string]int, len(scores))
+	for _, s := range scores {
+		bySeries[s.Player] = len(series)
+		series = append(series, scoreGraphSeries{
+			Name:   s.User,
+			Points: []scoreGraphPoint{{Time: start, Points: 0}},
+		})
+	}
+
+	for _, row := range solves {
+		i, ok := bySeries[row[0].(string)]
+		if !ok {
+			continue
+		}
+		last := series[i].Points[len(series[i].Points)-1]
+		series[i].Points = append(series[i].Points, scoreGraphPoint{
+			Time:   row[3].(time.Time),
+			Points: last.Points + row[2].(int),
+		})
+	}
+
+	for i := range series {
+		last := series[i].Points[len(series[i].Points)-1]
+		if last.Time.Before(end) {
+			series[i].Points = append(series[i].Points, scoreGraphPoint{Time: end, Points: last.Points})
+		}
+	}
+	return series, nil
+}
+
+func rGetScoreGraph(c *fiber.Ctx, ctf *ctf) error {
+	top := c.QueryInt("top", scoreGraphDefaultTop)
+	if top < 1 {
+		top = scoreGraphDefaultTop
+	}
+
+	// like the scoreboard, admins always see the live graph
+	series, err := ctf.scoreGraph(ctf.sessionIsAdmin(c), top)
+	if err != nil {
+		return handleError(c, err)
+	}
+	return c.JSON(series)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"net"
+	"os"
+	"strings"
+)
+
+const defaultListen = ":3000"
+
+// serverSettings configure how the web server is reached. They are read from
+// ctf.yml, can be overridden by the environment and by the flags of the serve
+// command and are fixed until the next restart.
+type serverSettings struct {
+	Listen         string
+	TLSCert        string
+	TLSKey         string
+	BasePath       string
+	TrustedProxies []string
+}
+
+// serverFlags are the flags of the serve command overriding ctf.yml.
+type serverFlags struct {
+	flags          *flag.FlagSet
+	listen         *string
+	tlsCert        *string
+	tlsKey         *string
+	basePath       *string
+	trustedProxies *string
+}
+
+func addServerFlags(flags *flag.FlagSet) serverFlags {
+	return serverFlags{
+		flags:          flags,
+		listen:         flags.String("listen", "", "listen on this address, e.g. 127.0.0.1:3000"),
+		tlsCert:        flags.String("tls-cert", "", "serve HTTPS with this certificate file"),
+		tlsKey:         flags.String("tls-key", "", "serve HTTPS with this key file"),
+		basePath:       flags.String("base-path", "", "serve the ctf below this path, e.g. /ctf"),
+		trustedProxies: flags.String("trusted-proxies", "", "comma separated IPs or ranges of trusted proxies"),
+	}
+}
+
+// serverSettings combines ctf.yml with the environment and the flags, where
+// flags take precedence over the environment.
+func (f serverFlags) serverSettings(conf configuration) (serverSettings, error) {
+	settings := serverSettings{
+		Listen:         conf.Listen,
+		TLSCert:        conf.TLSCert,
+		TLSKey:         conf.TLSKey,
+		BasePath:       conf.BasePath,
+		TrustedProxies: conf.TrustedProxies,
+	}
+
+	set := make(map[string]bool)
+	f.flags.Visit(func(fl *flag.Flag) {
+		set[fl.Name] = true
+	})
+	override := func(value *string, env, name string, flagValue string) {
+		if v, ok := os.LookupEnv(env); ok {
+			*value = v
+		}
+		if set[name] {
+			*value = flagValue
+		}
+	}
+	override(&settings.Listen, "CTFENGINE_LISTEN", "listen", *f.listen)
+	override(&settings.TLSCert, "CTFENGINE_TLS_CERT", "tls-cert", *f.tlsCert)
+	override(&settings.TLSKey, "CTFENGINE_TLS_KEY", "tls-key", *f.tlsKey)
+	override(&settings.BasePath, "CTFENGINE_BASE_PATH", "base-path", *f.basePath)
+
+	trustedProxies := strings.Join(settings.TrustedProxies, ",")
+	override(&trustedProxies, "CTFENGINE_TRUSTED_PROXIES", "trusted-proxies", *f.trustedProxies)
+	settings.TrustedProxies = nil
+	for _, proxy := range strings.Split(trustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			settings.TrustedProxies = append(settings.TrustedProxies, proxy)
+		}
+	}
+
+	if settings.Listen == "" {
+		settings.Listen = defaultListen
+	}
+	settings.BasePath = normalizeBasePath(settings.BasePath)
+
+	return settings, settings.validate()
+}
+
+func (s serverSettings) validate() error {
+	if (s.TLSCert == "") != (s.TLSKey == "") {
+		return fmt.Errorf("tlsCert and tlsKey have to be set together")
+	}
+	_, err := parseProxies(s.TrustedProxies)
+	return err
+}
+
+// proxyNetworks are the trusted proxies as networks, single IPs are networks
+// with one address.
+type proxyNetworks []*net.IPNet
+
+// parseProxy returns the network of a trusted proxy, which is an IP or a CIDR
+// range.
+func parseProxy(proxy string) (*net.IPNet, error) {
+	if ip := net.ParseIP(proxy); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	if _, network, err := net.ParseCIDR(proxy); err == nil {
+		return network, nil
+	}
+	return nil, fmt.Errorf("trusted proxy \"%s\" is neither an IP nor a range", proxy)
+}
+
+func parseProxies(proxies []string) (proxyNetworks, error) {
+	var networks proxyNetworks
+	for _, proxy := range proxies {
+		network, err := parseProxy(proxy)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func (p proxyNetworks) contains(ip net.IP) bool {
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP of the client of a request. For requests from
+// trusted proxies it is the rightmost address in X-Forwarded-For that is not
+// a trusted proxy itself. Addresses further left are sent by the client and
+// can be forged, so proxies have to append to the header instead of passing
+// it on unchanged.
+func (p proxyNetworks) clientIP(remote net.IP, forwardedFor string) string {
+	if !p.contains(remote) || forwardedFor == "" {
+		return remote.String()
+	}
+
+	hops := strings.Split(forwardedFor, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		remote = ip
+		if !p.contains(ip) {
+			break
+		}
+	}
+	return remote.String()
+}
+
+// clientIP returns the IP of the client of a request, see
+// proxyNetworks.clientIP.
+func (ctf *ctf) clientIP(c *fiber.Ctx) string {
+	return ctf.TrustedProxies.clientIP(c.Context().RemoteIP(), c.Get(fiber.HeaderXForwardedFor))
+}
+
+// normalizeBasePath returns the base path with a leading and without a
+// trailing slash, so that "/" and "" both mean no base path.
+func normalizeBasePath(basePath string) string {
+	basePath = strings.Trim(strings.TrimSpace(basePath), "/")
+	if basePath == "" {
+		return ""
+	}
+	return "/" + basePath
+}
+
+// basePathMiddleware serves the app below basePath. It strips the base path
+// before routing and adds it to the redirects of the handlers, which only
+// know the paths without it.
+func basePathMiddleware(basePath string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		path := c.Path()
+		if path != basePath && !strings.HasPrefix(path, basePath+"/") {
+			return fiber.ErrNotFound
+		}
+		c.Path("/" + strings.TrimPrefix(strings.TrimPrefix(path, basePath), "/"))
+
+		err := c.Next()
+
+		location := string(c.Response().Header.Peek(fiber.HeaderLocation))
+		if strings.HasPrefix(location, "/") && !strings.HasPrefix(location, "//") {
+			c.Set(fiber.HeaderLocation, basePath+location)
+		}
+		return err
+	}
+}
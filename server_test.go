@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseProxies([]string{"127.0.0.1", "10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		remote       string
+		forwardedFor string
+		want         string
+	}{
+		{"direct", "203.0.113.1", "", "203.0.113.1"},
+		{"untrusted remote", "203.0.113.1", "198.51.100.7", "203.0.113.1"},
+		{"trusted without header", "127.0.0.1", "", "127.0.0.1"},
+		{"one proxy", "127.0.0.1", "198.51.100.7", "198.51.100.7"},
+		{"forged by client", "127.0.0.1", "192.0.2.66, 198.51.100.7", "198.51.100.7"},
+		{"proxy chain", "127.0.0.1", "192.0.2.66, 198.51.100.7, 10.1.2.3", "198.51.100.7"},
+		{"only proxies", "127.0.0.1", "10.1.2.3", "10.1.2.3"},
+		{"malformed hop", "127.0.0.1", "198.51.100.7, garbage", "127.0.0.1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := proxies.clientIP(net.ParseIP(test.remote), test.forwardedFor)
+			if got != test.want {
+				t.Errorf("clientIP(%s, %q) = %s, want %s", test.remote, test.forwardedFor, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseProxy(t *testing.T) {
+	for _, proxy := range []string{"127.0.0.1", "::1", "10.0.0.0/8", "fd00::/8"} {
+		if _, err := parseProxy(proxy); err != nil {
+			t.Errorf("parseProxy(%s) failed: %s", proxy, err)
+		}
+	}
+	for _, proxy := range []string{"", "nope", "10.0.0.0/33"} {
+		if _, err := parseProxy(proxy); err == nil {
+			t.Errorf("parseProxy(%q) accepted an invalid proxy", proxy)
+		}
+	}
+}
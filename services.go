@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// serviceCheckInterval is the interval in which the containers of challenge
+// services are checked and crashed ones are restarted.
+const serviceCheckInterval = 10 * time.Second
+
+// serviceTimeout limits building and starting a single service.
+const serviceTimeout = 10 * time.Minute
+
+type serviceStatus struct {
+	Challenge string
+	Port      int
+	Instanced bool
+	State     string
+	Restarts  int
+	Error     string
+	Started   time.Time
+	built     bool
+}
+
+// serviceManager keeps the containers of all challenge services running.
+type serviceManager struct {
+	runtime  serviceRuntime
+	mu       sync.Mutex
+	statuses map[string]*serviceStatus
+	// instances serializes the allocation of instance ports
+	instances sync.Mutex
+}
+
+func newServiceManager(runtime serviceRuntime) *serviceManager {
+	return &serviceManager{runtime: runtime, statuses: make(map[string]*serviceStatus)}
+}
+
+// status returns the status of a service, creating it on first use.
+func (m *serviceManager) status(challengeID string) *serviceStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.statuses[challengeID]
+	if !ok {
+		s = &serviceStatus{Challenge: challengeID, State: containerMissing}
+		m.statuses[challengeID] = s
+	}
+	return s
+}
+
+func (m *serviceManager) update(challengeID string, update func(s *serviceStatus)) {
+	s := m.status(challengeID)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	update(s)
+}
+
+// list returns a copy of all statuses ordered by challenge.
+func (m *serviceManager) list() []serviceStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var statuses []serviceStatus
+	for _, s := range m.statuses {
+		statuses = append(statuses, *s)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Challenge < statuses[j].Challenge
+	})
+	return statuses
+}
+
+// desiredServices returns the ports of all challenges with a service and a
+// Dockerfile by challenge ID. If instanced is set, only services with
+// instances are returned, otherwise only shared ones. The caller has to hold
+// ctf.lock.
+func (ctf *ctf) desiredServices(instanced bool) map[string]int {
+	services := make(map[string]int)
+
+	for id, cha := range ctf.Challenges {
+		if cha.Service.Port == 0 || cha.Service.Instanced != instanced {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(ctf.Location, "challenges", id, "Dockerfile")); err != nil {
+			continue
+		}
+		services[id] = cha.Service.Port
+	}
+	return services
+}
+
+// buildService builds the image of a challenge unless it is already built.
+func (ctf *ctf) buildService(ctx context.Context, challengeID string, port int, rebuild bool) error {
+	m := ctf.Services
+	s := m.status(challengeID)
+
+	m.mu.Lock()
+	built := s.built
+	m.mu.Unlock()
+	if built && !rebuild {
+		return nil
+	}
+
+	err := m.runtime.build(ctx, serviceName(challengeID), filepath.Join(ctf.Location, "challenges", challengeID))
+	if err != nil {
+		fmt.Printf("[ERROR] could not build service \"%s\": %s!\n", challengeID, err)
+		m.update(challengeID, func(s *serviceStatus) {
+			s.Port, s.Error = port, err.Error()
+		})
+		return err
+	}
+	m.update(challengeID, func(s *serviceStatus) {
+		s.Port, s.Error, s.built = port, "", true
+	})
+	return nil
+}
+
+// startService builds the image of a challenge unless it is already built and
+// (re)starts its container. For instanced services only the image is built.
+func (ctf *ctf) startService(challengeID string, port int, rebuild, instanced bool) {
+	m := ctf.Services
+	ctx, cancel := context.WithTimeout(context.Background(), serviceTimeout)
+	defer cancel()
+
+	if ctf.buildService(ctx, challengeID, port, rebuild) != nil || instanced {
+		return
+	}
+
+	name := serviceName(challengeID)
+	err := m.runtime.start(ctx, containerSpec{Name: name, Image: name, Port: port, HostPort: port})
+	if err != nil {
+		fmt.Printf("[ERROR] could not start service \"%s\": %s!\n", challengeID, err)
+		m.update(challengeID, func(s *serviceStatus) {
+			s.Port, s.Error = port, err.Error()
+		})
+		return
+	}
+
+	fmt.Printf("[INFO] started service \"%s\" on port %d\n", challengeID, port)
+	m.update(challengeID, func(s *serviceStatus) {
+		s.Port, s.State, s.Error, s.Started = port, containerRunning, "", time.Now()
+	})
+}
+
+// checkServices starts missing services, restarts crashed ones and stops the
+// ones of removed challenges.
+func (ctf *ctf) checkServices() {
+	m := ctf.Services
+
+	ctf.lock.RLock()
+	desired := ctf.desiredServices(false)
+	instanced := ctf.desiredServices(true)
+	ctf.lock.RUnlock()
+
+	for _, s := range m.list() {
+		if _, ok := desired[s.Challenge]; ok {
+			continue
+		}
+		if _, ok := instanced[s.Challenge]; ok {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), serviceTimeout)
+		if err := m.runtime.stop(ctx, serviceName(s.Challenge)); err != nil {
+			fmt.Printf("[ERROR] could not stop service \"%s\": %s!\n", s.Challenge, err)
+		}
+		cancel()
+
+		m.mu.Lock()
+		delete(m.statuses, s.Challenge)
+		m.mu.Unlock()
+	}
+
+	for id, port := range desired {
+		ctx, cancel := context.WithTimeout(context.Background(), serviceTimeout)
+		state, err := m.runtime.state(ctx, serviceName(id))
+		cancel()
+		if err != nil {
+			m.update(id, func(s *serviceStatus) {
+				s.Error = err.Error()
+			})
+			continue
+		}
+
+		s := m.status(id)
+		m.mu.Lock()
+		started, changed := !s.Started.IsZero(), s.Port != port
+		s.State = state
+		m.mu.Unlock()
+
+		if state == containerRunning && !changed {
+			continue
+		}
+		if started && state != containerRunning {
+			fmt.Printf("[ERROR] service \"%s\" is %s, restarting it!\n", id, state)
+			m.update(id, func(s *serviceStatus) {
+				s.Restarts++
+			})
+		}
+		ctf.startService(id, port, false, false)
+	}
+
+	// instanced services only need their image, the instances are started
+	// by the players
+	for id, port := range instanced {
+		s := m.status(id)
+		m.mu.Lock()
+		built := s.built
+		s.Port, s.Instanced = port, true
+		m.mu.Unlock()
+		if !built {
+			ctf.startService(id, port, false, true)
+		}
+	}
+
+	ctf.reapInstances()
+}
+
+// superviseServices keeps the services running until ctx is done and stops
+// all services afterwards.
+func (ctf *ctf) superviseServices(ctx context.Context) {
+	ticker := time.NewTicker(serviceCheckInterval)
+	defer ticker.Stop()
+	ctf.checkServices()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctf.checkServices()
+		case <-ctx.Done():
+			ctf.stopServices()
+			return
+		}
+	}
+}
+
+func (ctf *ctf) stopServices() {
+	m := ctf.Services
+	ctf.stopInstances()
+	for _, s := range m.list() {
+		ctx, cancel := context.WithTimeout(context.Background(), serviceTimeout)
+		if err := m.runtime.stop(ctx, serviceName(s.Challenge)); err != nil {
+			fmt.Printf("[ERROR] could not stop service \"%s\": %s!\n", s.Challenge, err)
+		}
+		cancel()
+	}
+}
+
+func rGetAdminServices(c *fiber.Ctx, ctf *ctf) error {
+	var statuses []serviceStatus
+	var instances []instance
+	if ctf.Services != nil {
+		statuses = ctf.Services.list()
+		instances, _ = ctf.instances()
+	}
+	return renderWithSession(c, *ctf, "admin/services", fiber.Map{
+		"Enabled":   ctf.Services != nil,
+		"Services":  statuses,
+		"Instances": instances,
+	})
+}
+
+func rPostAdminServiceRestart(c *fiber.Ctx, ctf *ctf) error {
+	if ctf.Services == nil {
+		return c.Redirect("/admin/services")
+	}
+
+	challengeID := c.Params("challengeID")
+	port, shared := ctf.desiredServices(false)[challengeID]
+	if !shared {
+		var ok bool
+		if port, ok = ctf.desiredServices(true)[challengeID]; !ok {
+			ctf.addToast(c, "Service restart failed",
+				fmt.Sprintf("Challenge \"%s\" has no service.", challengeID))
+			return c.Redirect("/admin/services")
+		}
+	}
+
+	// building can take a while, so do not block the request
+	go ctf.startService(challengeID, port, true, !shared)
+
+	ctf.addToast(c, "Service restarting",
+		fmt.Sprintf("The service of \"%s\" is being rebuilt and restarted.", challengeID))
+	return c.Redirect("/admin/services")
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+const serviceTestConf = "title: test\nsessionTimeout: 600\nserviceRuntime: fake\n"
+
+// fakeState returns the state of a container in the fake runtime.
+func fakeState(t *testing.T, ctf *ctf, name string) string {
+	t.Helper()
+	state, err := ctf.Services.runtime.state(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestServiceLifecycle(t *testing.T) {
+	ctf := newTestCTF(t, serviceTestConf, map[string]string{
+		"web":   "name: web\nflag: CTF{web}\nservice:\n  port: 31337\n",
+		"plain": "name: plain\nflag: CTF{plain}\n",
+	})
+	fake := ctf.Services.runtime.(*fakeRuntime)
+	name := serviceName("web")
+
+	// start
+	ctf.checkServices()
+	if !fake.images[name] {
+		t.Fatal("image was not built")
+	}
+	if got := fakeState(t, ctf, name); got != containerRunning {
+		t.Fatalf("service is %s after the first check", got)
+	}
+	if got := fakeState(t, ctf, serviceName("plain")); got != containerMissing {
+		t.Fatalf("challenge without service got a container (%s)", got)
+	}
+	statuses := ctf.Services.list()
+	if len(statuses) != 1 || statuses[0].State != containerRunning || statuses[0].Port != 31337 {
+		t.Fatalf("unexpected statuses %+v", statuses)
+	}
+
+	// restart after a crash
+	fake.mu.Lock()
+	c := fake.containers[name]
+	c.state = containerExited
+	fake.containers[name] = c
+	fake.mu.Unlock()
+
+	ctf.checkServices()
+	if got := fakeState(t, ctf, name); got != containerRunning {
+		t.Fatalf("crashed service is %s after the check", got)
+	}
+	if restarts := ctf.Services.list()[0].Restarts; restarts != 1 {
+		t.Fatalf("restarts = %d, want 1", restarts)
+	}
+
+	// rebuild from the admin panel
+	delete(fake.images, name)
+	ctf.startService("web", 31337, true, false)
+	if !fake.images[name] || fakeState(t, ctf, name) != containerRunning {
+		t.Fatal("rebuilt service is not running")
+	}
+
+	// stop on shutdown
+	ctf.stopServices()
+	if got := fakeState(t, ctf, name); got != containerMissing {
+		t.Fatalf("service is %s after stopping all services", got)
+	}
+}
+
+func TestServiceOfRemovedChallenge(t *testing.T) {
+	ctf := newTestCTF(t, serviceTestConf, map[string]string{
+		"web": "name: web\nflag: CTF{web}\nservice:\n  port: 31337\n",
+	})
+
+	ctf.checkServices()
+	if got := fakeState(t, ctf, serviceName("web")); got != containerRunning {
+		t.Fatalf("service is %s after the first check", got)
+	}
+
+	delete(ctf.Challenges, "web")
+	ctf.checkServices()
+	if got := fakeState(t, ctf, serviceName("web")); got != containerMissing {
+		t.Fatalf("service of a removed challenge is %s", got)
+	}
+	if statuses := ctf.Services.list(); len(statuses) != 0 {
+		t.Fatalf("removed service is still listed: %+v", statuses)
+	}
+}
+
+func TestSuperviseServicesStopsOnCancel(t *testing.T) {
+	ctf := newTestCTF(t, serviceTestConf, map[string]string{
+		"web": "name: web\nflag: CTF{web}\nservice:\n  port: 31337\n",
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		ctf.superviseServices(ctx)
+		close(done)
+	}()
+
+	cancel()
+	<-done
+	if got := fakeState(t, ctf, serviceName("web")); got != containerMissing {
+		t.Fatalf("service is %s after the supervisor returned", got)
+	}
+}
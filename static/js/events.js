@@ -0,0 +1,86 @@
+// Subscribes to the live updates of /events. Events with a title are shown as
+// toast, scoreboard events refresh the element with the id "scoreboard" and
+// notify other scripts by a "ctf:scoreboard" event on the document.
+(function () {
+    "use strict";
+
+    if (!window.EventSource) {
+        return;
+    }
+
+    const basePathMeta = document.querySelector("meta[name='base-path']");
+    const basePath = basePathMeta ? basePathMeta.content : "";
+
+    function showToast(data) {
+        const container = document.querySelector(".toast-container");
+        if (!container || !data.title) {
+            return;
+        }
+
+        const toast = document.createElement("div");
+        toast.className = "toast show";
+        toast.setAttribute("role", "alert");
+        toast.setAttribute("aria-live", "assertive");
+        toast.setAttribute("aria-atomic", "true");
+
+        const header = document.createElement("div");
+        header.className = "toast-header";
+        const title = document.createElement("strong");
+        title.className = "me-auto";
+        title.textContent = data.title;
+        const close = document.createElement("button");
+        close.className = "btn-close";
+        close.type = "button";
+        close.setAttribute("aria-label", "Close");
+        close.addEventListener("click", () => toast.remove());
+        header.append(title, close);
+
+        const body = document.createElement("div");
+        body.className = "toast-body";
+        body.textContent = data.text;
+
+        toast.append(header, body);
+        container.appendChild(toast);
+    }
+
+    function refreshScoreboard() {
+        const scoreboard = document.getElementById("scoreboard");
+        if (!scoreboard) {
+            return;
+        }
+        fetch(window.location.href)
+            .then(response => response.text())
+            .then(html => {
+                const updated = new DOMParser().parseFromString(html, "text/html").getElementById("scoreboard");
+                if (updated) {
+                    scoreboard.replaceChildren(...updated.childNodes);
+                }
+                document.dispatchEvent(new Event("ctf:scoreboard"));
+            });
+    }
+
+    // Logged-in users get announcements as session toast on their next
+    // request. Load it now, so the toast is not shown twice.
+    function showAnnouncement(data) {
+        const container = document.querySelector(".toast-container");
+        fetch(basePath + "/announcements")
+            .then(response => response.text())
+            .then(html => {
+                const updated = new DOMParser().parseFromString(html, "text/html").querySelector(".toast-container");
+                const titles = updated ? [...updated.querySelectorAll(".toast-header strong")].map(e => e.textContent) : [];
+                if (container && titles.includes(data.title)) {
+                    container.replaceWith(updated);
+                } else {
+                    showToast(data);
+                }
+            })
+            .catch(() => showToast(data));
+    }
+
+    const source = new EventSource(basePath + "/events");
+    for (const type of ["solve", "firstblood"]) {
+        source.addEventListener(type, e => showToast(JSON.parse(e.data)));
+    }
+    source.addEventListener("announcement", e => showAnnouncement(JSON.parse(e.data)));
+    source.addEventListener("scoreboard", refreshScoreboard);
+})();
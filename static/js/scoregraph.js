@@ -0,0 +1,98 @@
+// Draws the cumulative points of the top players as a step chart. The data is
+// loaded from the URL in the data-src attribute of the element with the id
+// "score-graph", as returned by /score/graph.json.
+(function () {
+    "use strict";
+
+    const colors = ["#0d6efd", "#dc3545", "#198754", "#fd7e14", "#6f42c1",
+        "#20c997", "#d63384", "#ffc107", "#0dcaf0", "#6c757d"];
+    const width = 900, height = 360;
+    const margin = {top: 10, right: 10, bottom: 30, left: 50};
+    const ns = "http://www.w3.org/2000/svg";
+
+    function element(name, attributes, text) {
+        const e = document.createElementNS(ns, name);
+        for (const [key, value] of Object.entries(attributes)) {
+            e.setAttribute(key, value);
+        }
+        if (text !== undefined) {
+            e.textContent = text;
+        }
+        return e;
+    }
+
+    function draw(container, series) {
+        const points = series.flatMap(s => s.points.map(p => ({t: Date.parse(p.time), v: p.points})));
+        if (points.length === 0) {
+            return;
+        }
+        const minT = Math.min(...points.map(p => p.t));
+        const maxT = Math.max(...points.map(p => p.t), minT + 1);
+        const maxV = Math.max(...points.map(p => p.v), 1);
+
+        const x = t => margin.left + (t - minT) / (maxT - minT) * (width - margin.left - margin.right);
+        const y = v => height - margin.bottom - v / maxV * (height - margin.top - margin.bottom);
+
+        const svg = element("svg", {viewBox: `0 0 ${width} ${height}`, class: "w-100", role: "img"});
+
+        // axes with a few labels
+        svg.appendChild(element("line", {x1: x(minT), y1: y(0), x2: x(maxT), y2: y(0), stroke: "#adb5bd"}));
+        svg.appendChild(element("line", {x1: x(minT), y1: y(0), x2: x(minT), y2: y(maxV), stroke: "#adb5bd"}));
+        for (let i = 0; i <= 4; i++) {
+            const v = Math.round(maxV * i / 4);
+            const t = minT + (maxT - minT) * i / 4;
+            svg.appendChild(element("text", {
+                x: margin.left - 5, y: y(v) + 4, "text-anchor": "end", "font-size": 12
+            }, v));
+            svg.appendChild(element("text", {
+                x: x(t), y: height - 10, "text-anchor": "middle", "font-size": 12
+            }, new Date(t).toLocaleTimeString([], {hour: "2-digit", minute: "2-digit"})));
+        }
+
+        series.forEach((s, i) => {
+            let path = "";
+            s.points.forEach((p, j) => {
+                const px = x(Date.parse(p.time)), py = y(p.points);
+                if (j === 0) {
+                    path += `M${px},${py}`;
+                } else {
+                    // scores only change with a solve, so draw steps
+                    path += `H${px}V${py}`;
+                }
+            });
+            const line = element("path", {
+                d: path, fill: "none", stroke: colors[i % colors.length], "stroke-width": 2
+            });
+            line.appendChild(element("title", {}, s.name));
+            svg.appendChild(line);
+        });
+
+        const legend = document.createElement("div");
+        legend.className = "d-flex flex-wrap gap-3 small";
+        series.forEach((s, i) => {
+            const entry = document.createElement("span");
+            const swatch = document.createElement("span");
+            swatch.style.cssText = `display:inline-block;width:1em;height:.5em;margin-right:.3em;` +
+                `background:${colors[i % colors.length]}`;
+            entry.appendChild(swatch);
+            entry.appendChild(document.createTextNode(s.name));
+            legend.appendChild(entry);
+        });
+
+        container.replaceChildren(svg, legend);
+    }
+
+    function load(container) {
+        fetch(container.dataset.src)
+            .then(response => response.json())
+            .then(series => draw(container, series))
+            .catch(() => container.remove());
+    }
+
+    const container = document.getElementById("score-graph");
+    if (container) {
+        load(container);
+        // redraw on live updates, see events.js
+        document.addEventListener("ctf:scoreboard", () => load(container));
+    }
+})();
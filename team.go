@@ -0,0 +1,143 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"strings"
+)
+
+type team struct {
+	Name    string
+	Invite  string
+	Members []string
+}
+
+func (u *user) teamName() (string, error) {
+	t, err := u.team()
+	if err != nil {
+		return "", err
+	}
+	return t.Name, nil
+}
+
+func (u *user) team() (team, error) {
+	teamID, err := dbUserGetTeam(u.db, u.id)
+	if err != nil {
+		return team{}, err
+	}
+	name, invite, err := dbTeamGet(u.db, teamID)
+	if err != nil {
+		return team{}, err
+	}
+	_, members, err := dbTeamGetMembers(u.db, teamID)
+	if err != nil {
+		return team{}, err
+	}
+	return team{Name: name, Invite: invite, Members: members}, nil
+}
+
+// ensureTeamless returns the session user if it is not yet part of a team
+// and has neither solved a challenge nor bought a hint, so that points cannot
+// be carried from one team to another.
+func (ctf *ctf) ensureTeamless(c *fiber.Ctx) (user, error) {
+	if !ctf.Configuration.Teams {
+		return user{}, fmt.Errorf("teams are not enabled")
+	}
+	u, err := ctf.ensureLoggedIn(c)
+	if err != nil {
+		return user{}, err
+	}
+	_, err = dbUserGetTeam(ctf.Storage, u.id)
+	if err == nil {
+		return user{}, fmt.Errorf("user is already in a team")
+	}
+	if err != sql.ErrNoRows {
+		return user{}, err
+	}
+	active, err := dbUserHasActivity(ctf.Storage, u.id)
+	if err != nil {
+		return user{}, err
+	}
+	if active {
+		return user{}, fmt.Errorf("users who already scored cannot change teams")
+	}
+	return u, nil
+}
+
+func (ctf *ctf) createTeam(c *fiber.Ctx, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fmt.Errorf("empty team name cannot be used")
+	}
+
+	u, err := ctf.ensureTeamless(c)
+	if err != nil {
+		return err
+	}
+
+	teamID, err := dbTeamCreate(ctf.Storage, name, uuid.New().String())
+	if err != nil {
+		return err
+	}
+	return dbTeamAddMember(ctf.Storage, teamID, u.id)
+}
+
+func (ctf *ctf) joinTeam(c *fiber.Ctx, invite string) error {
+	u, err := ctf.ensureTeamless(c)
+	if err != nil {
+		return err
+	}
+
+	teamID, err := dbTeamGetByInvite(ctf.Storage, strings.TrimSpace(invite))
+	if err != nil {
+		return fmt.Errorf("invite code not accepted")
+	}
+
+	members, _, err := dbTeamGetMembers(ctf.Storage, teamID)
+	if err != nil {
+		return err
+	}
+	if ctf.Configuration.TeamSize > 0 && len(members) >= ctf.Configuration.TeamSize {
+		return fmt.Errorf("team is full")
+	}
+
+	return dbTeamAddMember(ctf.Storage, teamID, u.id)
+}
+
+func (ctf *ctf) leaveTeam(c *fiber.Ctx) error {
+	if !ctf.Configuration.Teams {
+		return fmt.Errorf("teams are not enabled")
+	}
+	u, err := ctf.ensureLoggedIn(c)
+	if err != nil {
+		return err
+	}
+
+	teamID, err := dbUserGetTeam(ctf.Storage, u.id)
+	if err != nil {
+		return err
+	}
+	active, err := dbUserHasActivity(ctf.Storage, u.id)
+	if err != nil {
+		return err
+	}
+	if active {
+		return fmt.Errorf("users who already scored cannot change teams")
+	}
+
+	if err = dbTeamRemoveMember(ctf.Storage, u.id); err != nil {
+		return err
+	}
+
+	// remove teams without members
+	members, _, err := dbTeamGetMembers(ctf.Storage, teamID)
+	if err != nil {
+		return err
+	}
+	if len(members) == 0 {
+		return dbTeamDelete(ctf.Storage, teamID)
+	}
+	return nil
+}
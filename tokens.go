@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiTokenPrefix makes API tokens recognizable, e.g. for secret scanners.
+const apiTokenPrefix = "ctf_"
+
+type apiToken struct {
+	ID       int
+	Name     string
+	Created  time.Time
+	LastUsed time.Time
+}
+
+// hashAPIToken returns the hash under which a token is stored. Tokens are
+// random, so a fast hash is sufficient.
+func hashAPIToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// createAPIToken creates a token for the session user. The token is only
+// returned here, the database only stores its hash.
+func (ctf *ctf) createAPIToken(c *fiber.Ctx, name string) (string, error) {
+	u, err := ctf.ensureLoggedIn(c)
+	if err != nil {
+		return "", err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("empty token name cannot be used")
+	}
+
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return "", err
+	}
+	token := apiTokenPrefix + hex.EncodeToString(b)
+
+	if err = dbInsertAPIToken(ctf.Storage, u.id, name, hashAPIToken(token)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (ctf *ctf) apiTokens(c *fiber.Ctx) ([]apiToken, error) {
+	var tokens []apiToken
+
+	u, err := ctf.ensureLoggedIn(c)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := dbGetAPITokens(ctf.Storage, u.id)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		tokens = append(tokens, apiToken{
+			ID:       row[0].(int),
+			Name:     row[1].(string),
+			Created:  row[2].(time.Time),
+			LastUsed: row[3].(time.Time),
+		})
+	}
+	return tokens, nil
+}
+
+func (ctf *ctf) revokeAPIToken(c *fiber.Ctx, tokenID int) error {
+	u, err := ctf.ensureLoggedIn(c)
+	if err != nil {
+		return err
+	}
+	return dbDeleteAPIToken(ctf.Storage, u.id, tokenID)
+}
+
+// tokenMiddleware authenticates requests carrying an "Authorization: Bearer"
+// header, see ensureLoggedIn. Requests with an unknown token are rejected.
+func (ctf *ctf) tokenMiddleware(c *fiber.Ctx) error {
+	header := c.Get(fiber.HeaderAuthorization)
+	if !strings.HasPrefix(header, "Bearer ") {
+		return c.Next()
+	}
+
+	userID, err := dbAPITokenGetUser(ctf.Storage, hashAPIToken(strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))))
+	if err != nil {
+		return apiError(c, fiber.StatusUnauthorized, fmt.Errorf("invalid API token"))
+	}
+	c.Locals("user", userID)
+	return c.Next()
+}
+
+func rGetProfile(c *fiber.Ctx, ctf *ctf, newToken string) error {
+	tokens, err := ctf.apiTokens(c)
+	if err != nil {
+		ctf.addToast(c, "Login needed",
+			"You need to log in to view your profile.")
+		return c.Redirect("/")
+	}
+
+	return renderWithSession(c, *ctf, "profile", fiber.Map{
+		"Tokens":   tokens,
+		"NewToken": newToken,
+	})
+}
+
+func rPostProfileToken(c *fiber.Ctx, ctf *ctf) error {
+	payload := struct {
+		Name string `form:"name"`
+	}{}
+
+	if err := c.BodyParser(&payload); err != nil {
+		return err
+	}
+
+	token, err := ctf.createAPIToken(c, payload.Name)
+	if err != nil {
+		ctf.addToast(c, "Token creation failed",
+			fmt.Sprintf("The token could not be created: %s.", err))
+		return c.Redirect("/profile")
+	}
+
+	// the token is shown once, so render instead of redirecting
+	return rGetProfile(c, ctf, token)
+}
+
+func rPostProfileTokenRevoke(c *fiber.Ctx, ctf *ctf) error {
+	tokenID, err := strconv.Atoi(c.Params("tokenID"))
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	if err = ctf.revokeAPIToken(c, tokenID); err != nil {
+		return handleError(c, err)
+	}
+	return c.Redirect("/profile")
+}
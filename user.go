@@ -1,7 +1,6 @@
 package main
 
 import (
-	"crypto/sha256"
 	"database/sql"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
@@ -29,9 +28,45 @@ func (u *user) name() (string, error) {
 	}
 }
 
-func (u *user) score() (int, error) {
+// memberIDs returns the IDs of all users whose solves and hints count
+// together with the ones of u, i.e. the members of u's team or only u
+// itself if u has no team.
+func (u *user) memberIDs() ([]int, error) {
 	//goland:noinspection GoDirectComparisonOfErrors
-	switch score, err := dbUserGetScore(u.db, u.id); err {
+	switch team, err := dbUserGetTeam(u.db, u.id); err {
+	case sql.ErrNoRows:
+		return []int{u.id}, nil
+	case nil:
+		ids, _, err := dbTeamGetMembers(u.db, team)
+		if err != nil {
+			return nil, err
+		}
+		return ids, nil
+	default:
+		return nil, err
+	}
+}
+
+// playerKey identifies who u is competing as, see dbGetPlayers.
+func (u *user) playerKey() (string, error) {
+	//goland:noinspection GoDirectComparisonOfErrors
+	switch team, err := dbUserGetTeam(u.db, u.id); err {
+	case sql.ErrNoRows:
+		return fmt.Sprintf("u%d", u.id), nil
+	case nil:
+		return fmt.Sprintf("t%d", team), nil
+	default:
+		return "", err
+	}
+}
+
+func (u *user) score(values map[string]int) (int, error) {
+	ids, err := u.memberIDs()
+	if err != nil {
+		return 0, err
+	}
+	//goland:noinspection GoDirectComparisonOfErrors
+	switch score, err := dbUserGetScore(u.db, ids, values); err {
 	case sql.ErrNoRows:
 		return 0, fmt.Errorf("no row in table users with id %d", u.id)
 	case nil:
@@ -55,24 +90,31 @@ func (ctf *ctf) setSessionKey(c *fiber.Ctx, id string, key interface{}) error {
 }
 
 func (ctf *ctf) login(c *fiber.Ctx, username, password string) (user, error) {
-	var id int
-	var salt string
-	var hash string
-	row := ctf.Storage.QueryRow(`SELECT id, password, salt FROM users WHERE name=$1;`, username)
 	//goland:noinspection GoDirectComparisonOfErrors
-	switch err := row.Scan(&id, &hash, &salt); err {
+	switch id, hash, salt, err := dbUserGetPassword(ctf.Storage, username); err {
 	case sql.ErrNoRows:
 		return user{}, fmt.Errorf("cannot Login: user does not exist")
 	case nil:
-		hashCalculated := sha256.Sum256([]byte(password + salt))
-		if hash[:] == string(hashCalculated[:]) {
-			err = ctf.setSessionKey(c, "user", id)
-			if err != nil {
-				return user{}, err
+		ok, needsRehash, err := verifyPassword(password, hash, salt)
+		if err != nil {
+			return user{}, err
+		}
+		if !ok {
+			return user{}, fmt.Errorf("cannot Login: password is wrong")
+		}
+		if needsRehash {
+			// upgrade legacy or outdated hashes transparently
+			if newHash, err := hashPassword(password); err == nil {
+				if err = dbUserSetPassword(ctf.Storage, id, newHash); err != nil {
+					fmt.Printf("[ERROR] could not rehash password of user %d: %s!\n", id, err)
+				}
 			}
-			return user{id: id, db: ctf.Storage}, nil
 		}
-		return user{}, fmt.Errorf("cannot Login: password is wrong")
+		err = ctf.setSessionKey(c, "user", id)
+		if err != nil {
+			return user{}, err
+		}
+		return user{id: id, db: ctf.Storage}, nil
 	default:
 		return user{}, err
 	}